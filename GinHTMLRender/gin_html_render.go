@@ -36,12 +36,16 @@
 package GinHTMLRender
 
 import (
+	"context"
 	"html/template"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin/render"
+	"go.opentelemetry.io/otel"
 )
 
 const (
@@ -63,6 +67,43 @@ type Render struct {
 	Layout       string
 	Ext          string
 	Debug        bool
+	// Observe, when set, is called with the time each render took
+	Observe func(name string, took time.Duration)
+	// Trace starts an OpenTelemetry span per render
+	Trace bool
+}
+
+// ContextWriter is a response writer carrying its request's context. Render
+// spans started while writing to one join the request's trace.
+type ContextWriter interface {
+	Context() context.Context
+}
+
+// timedHTML is render.HTML reporting its render time to Observe and tracing it
+type timedHTML struct {
+	render.HTML
+	name    string
+	observe func(name string, took time.Duration)
+	trace   bool
+}
+
+// Render implements gin's render interface
+func (t timedHTML) Render(w http.ResponseWriter) error {
+	if t.trace {
+		ctx := context.Background()
+		if cw, ok := w.(ContextWriter); ok {
+			ctx = cw.Context()
+		}
+		_, span := otel.Tracer("GinHTMLRender").Start(ctx, "render "+t.name)
+		defer span.End()
+	}
+
+	start := time.Now()
+	err := t.HTML.Render(w)
+	if t.observe != nil {
+		t.observe(t.name, time.Since(start))
+	}
+	return err
 }
 
 // Add assigns the name to the template
@@ -97,10 +138,14 @@ func (r *Render) Instance(name string, data interface{}) render.Render {
 		tpl = r.Templates[name]
 	}
 
-	return render.HTML{
+	html := render.HTML{
 		Template: tpl,
 		Data:     data,
 	}
+	if r.Observe != nil || r.Trace {
+		return timedHTML{HTML: html, name: name, observe: r.Observe, trace: r.Trace}
+	}
+	return html
 }
 
 // loadTemplate parses the specified template and returns it
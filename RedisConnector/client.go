@@ -0,0 +1,243 @@
+package RedisConnector
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+/**
+ * Options for New. Host and Port name any node of the
+ * cluster, the rest of the cluster is found with CLUSTER NODES.
+ */
+type Options struct {
+	Host     string
+	Port     string
+	Password string
+
+	// keys collected by Add before a pipeline burst, 10 when 0
+	BurstSize int
+
+	// MOVED and ASK redirects followed per command, 5 when 0
+	MaxRedirects int
+
+	// receives the connector's messages with the application's
+	// levels, 0 (debug) to 3 (error). Nil discards them.
+	Log func(level int, message string)
+}
+
+/**
+ * Client for a Redis cluster. Commands go straight to the
+ * master owning the key's hash slot. Safe for concurrent use.
+ */
+type Client struct {
+	lastRefresh int64 // UnixNano of the last slot map refresh, first for 64 bit alignment
+	refreshing  int32 // 1 while a refresh runs
+
+	opts     Options
+	mu       sync.RWMutex
+	cluster  *ClusterScenario
+	pipeline *PipelineData
+	signals  chan os.Signal
+	closed   bool
+}
+
+/**
+ * Connects to the cluster at opts.Host:opts.Port and maps its
+ * hash slots to master nodes. Nothing in the package connects
+ * or installs signal handlers before New is called.
+ */
+func New(ctx context.Context, opts Options) (*Client, error) {
+	if opts.Host == "" || opts.Port == "" {
+		return nil, errors.New("redis host and port are required")
+	}
+	if opts.BurstSize <= 0 {
+		opts.BurstSize = 10
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = defaultMaxRedirects
+	}
+	if opts.Log == nil {
+		opts.Log = func(int, string) {}
+	}
+
+	cluster, err := buildNodeMappings(ctx, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		opts:     opts,
+		cluster:  cluster,
+		pipeline: newPipelineData(cluster, uint64(opts.BurstSize), opts.Log),
+	}, nil
+}
+
+/**
+ * Current cluster mapping and pipeline, swapped as a pair by Reload
+ */
+func (c *Client) state() (*ClusterScenario, *PipelineData) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cluster, c.pipeline
+}
+
+/**
+ * Current cluster mapping and pipeline, counted as in use until
+ * release is called so a reload does not close a connection under
+ * a running command or burst
+ */
+func (c *Client) acquire() (cluster *ClusterScenario, pipeline *PipelineData, release func()) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.cluster.inUse.Add(1)
+	return c.cluster, c.pipeline, c.cluster.inUse.Done
+}
+
+/**
+ * Rebuilds the node mappings. Connections to nodes still in the
+ * cluster are kept. Once the commands running on the old mapping
+ * are done its pending pipeline data is burst to the old nodes,
+ * then the connections no longer needed are closed.
+ */
+func (c *Client) Reload(ctx context.Context) error {
+	prevCluster, _ := c.state()
+	cluster, err := buildNodeMappings(ctx, c.opts, prevCluster)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed || c.cluster != prevCluster {
+		// closed meanwhile, or another reload won
+		c.mu.Unlock()
+		cluster.closeExcept(prevCluster)
+		return errors.New("redis client closed or reloaded concurrently")
+	}
+	prevPipeline := c.pipeline
+	c.cluster = cluster
+	c.pipeline = newPipelineData(cluster, uint64(c.opts.BurstSize), c.opts.Log)
+	c.mu.Unlock()
+
+	// nothing acquires the old mapping once it is swapped out
+	prevCluster.inUse.Wait()
+	prevPipeline.Burst(ctx)
+	prevCluster.closeExcept(cluster)
+	c.opts.Log(1, "Redis node mappings rebuilt")
+	return nil
+}
+
+/**
+ * Rebuilds the node mappings whenever sig arrives, making
+ * possible realtime reloading of mappings without restarting
+ * the program IF NEEDED, e.g. killall -s USR2 contactmanager
+ */
+func (c *Client) ReloadOnSignal(sig os.Signal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.signals != nil || c.closed {
+		return
+	}
+	c.signals = make(chan os.Signal, 1)
+	signal.Notify(c.signals, sig)
+
+	go func(signals chan os.Signal) {
+		for range signals {
+			if err := c.Reload(context.Background()); err != nil {
+				c.opts.Log(3, "Redis reload failed: "+err.Error())
+			}
+		}
+	}(c.signals)
+}
+
+/**
+ * Bursts pending pipeline data, stops the signal handler
+ * and closes every node connection
+ */
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.signals != nil {
+		signal.Stop(c.signals)
+		close(c.signals)
+	}
+	cluster, pipeline := c.cluster, c.pipeline
+	c.mu.Unlock()
+
+	pipeline.Burst(context.Background())
+	return cluster.Close()
+}
+
+/**
+ * Redis GET, traced as a child of the span in ctx. A missing
+ * key returns redis.Nil.
+ */
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	var cmd *redis.StringCmd
+	err := c.do(ctx, "GET", key, func(r redis.Cmdable) redis.Cmder {
+		cmd = r.Get(key)
+		return cmd
+	})
+	if err != nil {
+		return "", err
+	}
+	return cmd.Val(), nil
+}
+
+/**
+ * Redis SET without expiry, traced as a child of the span in ctx
+ */
+func (c *Client) Set(ctx context.Context, key string, val interface{}) error {
+	return c.do(ctx, "SET", key, func(r redis.Cmdable) redis.Cmder {
+		return r.Set(key, val, 0)
+	})
+}
+
+/**
+ * Counts key for the next pipeline burst, which sends the
+ * collected counts as INCRBY to each node. A burst set off
+ * by key is traced as a child of the span in ctx.
+ */
+func (c *Client) Add(ctx context.Context, key string) {
+	_, pipeline, release := c.acquire()
+	defer release()
+	pipeline.addNodeData(ctx, key)
+}
+
+/**
+ * Bursts the collected pipeline data now, traced as a child
+ * of the span in ctx
+ */
+func (c *Client) Flush(ctx context.Context) {
+	_, pipeline, release := c.acquire()
+	defer release()
+	pipeline.Burst(ctx)
+}
+
+func (c *Client) LastBurstResults() map[string]int64 {
+	_, pipeline := c.state()
+	return pipeline.LastBurstResults()
+}
+
+/**
+ * Returns the master client owning key's hash slot
+ */
+func (c *Client) GetRedisClientByKey(key string) *redis.Client {
+	cluster, _ := c.state()
+	return cluster.GetRedisClientAdapter(HashSlot(key))
+}
+
+/**
+ * Pings every master node, keyed by node address
+ */
+func (c *Client) PingNodes() map[string]error {
+	cluster, _, release := c.acquire()
+	defer release()
+	return cluster.PingNodes()
+}
@@ -0,0 +1,270 @@
+package RedisConnector
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"net"
+	"testing"
+	"time"
+)
+
+// newFakeCluster starts two masters splitting the hash slots in half
+func newFakeCluster(t *testing.T) (*fakeRedis, *fakeRedis) {
+	a, b := newFakeRedis(t), newFakeRedis(t)
+	lines := []string{a.nodeLine("a", "0-8191"), b.nodeLine("b", "8192-16383")}
+	a.setNodes(lines...)
+	b.setNodes(lines...)
+	return a, b
+}
+
+func newTestClient(t *testing.T, seed *fakeRedis, opts Options) *Client {
+	opts.Host, opts.Port, _ = net.SplitHostPort(seed.addr)
+	client, err := New(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// owner is the node of a two node fake cluster serving key
+func owner(key string, a, b *fakeRedis) *fakeRedis {
+	if HashSlot(key) < 8192 {
+		return a
+	}
+	return b
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(context.Background(), Options{Host: "127.0.0.1"}); err == nil {
+		t.Error("New without a port succeeded")
+	}
+
+	a, b := newFakeCluster(t)
+	client := newTestClient(t, a, Options{})
+
+	for _, key := range []string{"alice", "bob", "{user1}.name"} {
+		want := owner(key, a, b).addr
+		if got := client.GetRedisClientByKey(key).Options().Addr; got != want {
+			t.Errorf("%s maps to %s, want %s", key, got, want)
+		}
+	}
+	for addr, err := range client.PingNodes() {
+		if err != nil {
+			t.Errorf("ping %s: %v", addr, err)
+		}
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	a, b := newFakeCluster(t)
+	client := newTestClient(t, a, Options{})
+	ctx := context.Background()
+
+	for _, key := range []string{"alice", "bob", "carol"} {
+		if err := client.Set(ctx, key, key+"-value"); err != nil {
+			t.Fatalf("set %s: %v", key, err)
+		}
+		if got := owner(key, a, b).get(key); got != key+"-value" {
+			t.Errorf("%s stored %q on its owner", key, got)
+		}
+		got, err := client.Get(ctx, key)
+		if err != nil || got != key+"-value" {
+			t.Errorf("get %s = %q, %v", key, got, err)
+		}
+	}
+	if _, err := client.Get(ctx, "missing"); err != redis.Nil {
+		t.Errorf("missing key: %v, want redis.Nil", err)
+	}
+}
+
+func TestAddFlush(t *testing.T) {
+	a, b := newFakeCluster(t)
+	client := newTestClient(t, a, Options{BurstSize: 4})
+	ctx := context.Background()
+
+	client.Add(ctx, "hits.alice")
+	client.Add(ctx, "hits.alice")
+	client.Add(ctx, "hits.bob")
+	if got := owner("hits.alice", a, b).get("hits.alice"); got != "" {
+		t.Fatalf("counted %q before a burst", got)
+	}
+
+	// the fourth key fills the burst
+	client.Add(ctx, "hits.alice")
+	if got := owner("hits.alice", a, b).get("hits.alice"); got != "3" {
+		t.Errorf("hits.alice = %q after the burst, want 3", got)
+	}
+	if got := owner("hits.bob", a, b).get("hits.bob"); got != "1" {
+		t.Errorf("hits.bob = %q after the burst, want 1", got)
+	}
+
+	client.Add(ctx, "hits.bob")
+	client.Flush(ctx)
+	if got := owner("hits.bob", a, b).get("hits.bob"); got != "2" {
+		t.Errorf("hits.bob = %q after Flush, want 2", got)
+	}
+	for k, v := range client.LastBurstResults() {
+		if v != 2 {
+			t.Errorf("last burst %s = %d, want 2", k, v)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	a, b := newFakeCluster(t)
+	client := newTestClient(t, a, Options{})
+	ctx := context.Background()
+
+	client.Add(ctx, "hits.carol")
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := owner("hits.carol", a, b).get("hits.carol"); got != "1" {
+		t.Errorf("Close left pending counts, hits.carol = %q", got)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if err := client.Set(ctx, "alice", "x"); err == nil {
+		t.Error("Set after Close succeeded")
+	}
+	if err := client.Reload(ctx); err == nil {
+		t.Error("Reload after Close succeeded")
+	}
+}
+
+func TestParseSlotRanges(t *testing.T) {
+	tests := []struct {
+		fields []string
+		want   []slotRange
+		err    bool
+	}{
+		{[]string{"0-5460"}, []slotRange{{0, 5460}}, false},
+		{[]string{"0-100", "200", "300-16383"}, []slotRange{{0, 100}, {200, 200}, {300, 16383}}, false},
+		{[]string{"0-100", "[101->-b2c3]", "[102-<-d4e5]"}, []slotRange{{0, 100}}, false},
+		{[]string{"[101->-b2c3]"}, nil, false},
+		{nil, nil, false},
+		{[]string{"x-100"}, nil, true},
+		{[]string{"100-50"}, nil, true},
+		{[]string{"0-16384"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSlotRanges(tt.fields)
+		if (err != nil) != tt.err {
+			t.Errorf("parseSlotRanges(%q) error %v", tt.fields, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseSlotRanges(%q) = %v, want %v", tt.fields, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseSlotRanges(%q) = %v, want %v", tt.fields, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestMappingOnlyReadsTheCluster(t *testing.T) {
+	a, b, replica := newFakeRedis(t), newFakeRedis(t), newFakeRedis(t)
+	_, port, _ := net.SplitHostPort(replica.addr)
+	lines := []string{
+		// a serves two ranges and hands slot 5001 over to b
+		a.nodeLine("a", "0-5000", "10001-16383", "[5001->-b]"),
+		b.nodeLine("b", "5001-10000", "[5001-<-a]"),
+		"r " + replica.addr + "@1" + port + " slave a 0 0 1 connected",
+	}
+	for _, node := range []*fakeRedis{a, b, replica} {
+		node.setNodes(lines...)
+	}
+	client := newTestClient(t, a, Options{})
+	if err := client.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for slot, want := range map[int]*fakeRedis{0: a, 5000: a, 5001: b, 10000: b, 10001: a, 16383: a} {
+		cluster, _ := client.state()
+		if got := cluster.GetRedisClientAdapter(slot); got == nil || got.Options().Addr != want.addr {
+			t.Errorf("slot %d maps to %v, want %s", slot, got, want.addr)
+		}
+	}
+	for _, node := range []*fakeRedis{a, b, replica} {
+		if n := node.received("CLUSTER MEET") + node.received("CLUSTER REPLICATE"); n > 0 {
+			t.Errorf("%s was sent %d cluster changes", node.addr, n)
+		}
+	}
+}
+
+func TestAddUnmappedSlot(t *testing.T) {
+	a := newFakeRedis(t)
+	a.setNodes(a.nodeLine("a", "8192-16383"))
+	client := newTestClient(t, a, Options{})
+	ctx := context.Background()
+
+	// "hits.bob" hashes to slot 3122, which no node serves
+	client.Add(ctx, "hits.alice")
+	client.Add(ctx, "hits.bob")
+	client.Flush(ctx)
+	if got := a.get("hits.alice"); got != "1" {
+		t.Errorf("hits.alice = %q, want 1", got)
+	}
+	if a.received("INCRBY hits.bob") > 0 {
+		t.Error("a key without a node was sent")
+	}
+}
+
+func TestReloadWaitsForRunningCommands(t *testing.T) {
+	a, b := newFakeCluster(t)
+	client := newTestClient(t, a, Options{})
+	ctx := context.Background()
+
+	key := "alice"
+	if owner(key, a, b) != b {
+		key = "bob"
+	}
+	b.set(key, "value")
+	running, finish := make(chan struct{}), make(chan struct{})
+	b.setHandle(func(args []string) (interface{}, bool) {
+		if args[0] == "GET" {
+			close(running)
+			<-finish
+		}
+		return nil, false
+	})
+
+	type result struct {
+		val string
+		err error
+	}
+	got := make(chan result)
+	go func() {
+		val, err := client.Get(ctx, key)
+		got <- result{val, err}
+	}()
+	<-running
+
+	// b leaves the cluster while the GET waits for its answer
+	a.setNodes(a.nodeLine("a", "0-16383"))
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- client.Reload(ctx)
+	}()
+	select {
+	case err := <-reloaded:
+		t.Fatalf("Reload returned %v before the GET finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(finish)
+	if r := <-got; r.err != nil || r.val != "value" {
+		t.Errorf("get = %q, %v", r.val, r.err)
+	}
+	if err := <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+	if addr := client.GetRedisClientByKey(key).Options().Addr; addr != a.addr {
+		t.Errorf("%s maps to %s after the reload, want %s", key, addr, a.addr)
+	}
+}
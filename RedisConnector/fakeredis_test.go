@@ -0,0 +1,198 @@
+package RedisConnector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// status and replyError are the simple string and error replies, a string
+// is answered as a bulk string, nil as a missing one
+type status string
+type replyError string
+
+// fakeRedis is one cluster node speaking enough RESP for the connector. Keys
+// live in data, CLUSTER NODES answers nodes. handle, when set, answers a
+// command first, e.g. with a MOVED reply.
+type fakeRedis struct {
+	addr string
+	ln   net.Listener
+
+	mu       sync.Mutex
+	data     map[string]string
+	nodes    string
+	handle   func(args []string) (interface{}, bool)
+	commands []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{addr: ln.Addr().String(), ln: ln, data: map[string]string{}}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// nodeLine is this node's CLUSTER NODES line as a master of slots
+func (f *fakeRedis) nodeLine(id string, slots ...string) string {
+	_, port, _ := net.SplitHostPort(f.addr)
+	return fmt.Sprintf("%s %s@1%s master - 0 0 1 connected %s", id, f.addr, port, strings.Join(slots, " "))
+}
+
+func (f *fakeRedis) setNodes(lines ...string) {
+	f.mu.Lock()
+	f.nodes = strings.Join(lines, "\n") + "\n"
+	f.mu.Unlock()
+}
+
+func (f *fakeRedis) setHandle(handle func(args []string) (interface{}, bool)) {
+	f.mu.Lock()
+	f.handle = handle
+	f.mu.Unlock()
+}
+
+func (f *fakeRedis) set(key string, val string) {
+	f.mu.Lock()
+	f.data[key] = val
+	f.mu.Unlock()
+}
+
+func (f *fakeRedis) get(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.data[key]
+}
+
+// received counts the commands starting with prefix, e.g. "CLUSTER MEET"
+func (f *fakeRedis) received(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, cmd := range f.commands {
+		if strings.HasPrefix(cmd, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(encodeReply(f.answer(args))); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) answer(args []string) interface{} {
+	args[0] = strings.ToUpper(args[0])
+	if args[0] == "CLUSTER" && len(args) > 1 {
+		args[1] = strings.ToUpper(args[1])
+	}
+	f.mu.Lock()
+	f.commands = append(f.commands, strings.Join(args, " "))
+	handle := f.handle
+	f.mu.Unlock()
+
+	// outside the lock, handle may block to hold a command in flight
+	if handle != nil {
+		if reply, ok := handle(args); ok {
+			return reply
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch args[0] {
+	case "PING":
+		return status("PONG")
+	case "ASKING":
+		return status("OK")
+	case "GET":
+		if v, ok := f.data[args[1]]; ok {
+			return v
+		}
+		return nil
+	case "SET":
+		f.data[args[1]] = args[2]
+		return status("OK")
+	case "INCRBY":
+		n, _ := strconv.ParseInt(f.data[args[1]], 10, 64)
+		by, _ := strconv.ParseInt(args[2], 10, 64)
+		f.data[args[1]] = strconv.FormatInt(n+by, 10)
+		return n + by
+	case "CLUSTER":
+		if args[1] == "NODES" {
+			return f.nodes
+		}
+	}
+	return replyError("ERR unknown command " + args[0])
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func encodeReply(reply interface{}) []byte {
+	switch v := reply.(type) {
+	case status:
+		return []byte("+" + string(v) + "\r\n")
+	case replyError:
+		return []byte("-" + string(v) + "\r\n")
+	case int64:
+		return []byte(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		return []byte("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	}
+	return []byte("$-1\r\n")
+}
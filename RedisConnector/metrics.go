@@ -0,0 +1,72 @@
+package RedisConnector
+
+import (
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+/**
+ * Command latencies per node and command, registered with the default
+ * Prometheus registry the application serves on /metrics
+ */
+var commandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "contactmanager",
+	Subsystem: "redis",
+	Name:      "command_duration_seconds",
+	Help:      "Redis command latency by node and command.",
+	Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+}, []string{"node", "command"})
+
+var commandErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "contactmanager",
+	Subsystem: "redis",
+	Name:      "command_errors_total",
+	Help:      "Failed Redis commands by node and command.",
+}, []string{"node", "command"})
+
+/**
+ * Number of keys sent per node in one PipelineData.Burst
+ */
+var burstSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "contactmanager",
+	Subsystem: "redis",
+	Name:      "pipeline_burst_size",
+	Help:      "Keys per node sent by a pipeline burst.",
+	Buckets:   prometheus.LinearBuckets(1, 2, 10),
+}, []string{"node"})
+
+/**
+ * Times every command and pipeline the client sends to the node at address.
+ * A missing key (redis.Nil) is an answer, not an error.
+ */
+func instrumentClient(client *redis.Client, address string) {
+	client.WrapProcess(func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			start := time.Now()
+			err := old(cmd)
+			observeCommand(address, cmd.Name(), time.Since(start), err)
+			return err
+		}
+	})
+	client.WrapProcessPipeline(func(old func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			start := time.Now()
+			err := old(cmds)
+			observeCommand(address, "pipeline", time.Since(start), err)
+			return err
+		}
+	})
+}
+
+func observeCommand(address string, command string, took time.Duration, err error) {
+	commandDuration.WithLabelValues(address, command).Observe(took.Seconds())
+	if err != nil && err != redis.Nil {
+		commandErrors.WithLabelValues(address, command).Inc()
+	}
+}
+
+func observeBurst(address string, keys int) {
+	burstSize.WithLabelValues(address).Observe(float64(keys))
+}
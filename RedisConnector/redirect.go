@@ -0,0 +1,149 @@
+package RedisConnector
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel/attribute"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// redirects followed for one command before giving up
+	defaultMaxRedirects = 5
+
+	// MOVED replies during a reshard arrive in bursts, one
+	// slot map refresh per interval covers them
+	refreshInterval = time.Second
+)
+
+/**
+ * A MOVED or ASK reply: the slot is served by the node at address,
+ * for good (MOVED) or for the next command only while it is being
+ * migrated (ASK)
+ */
+type redirect struct {
+	ask     bool
+	slot    int
+	address string
+}
+
+/**
+ * Parses "MOVED 3999 127.0.0.1:6381" and "ASK 3999 127.0.0.1:6381"
+ * error replies, ok is false for any other error
+ */
+func parseRedirect(err error) (redirect, bool) {
+	if err == nil || err == redis.Nil {
+		return redirect{}, false
+	}
+	parts := strings.Fields(err.Error())
+	if len(parts) != 3 || (parts[0] != "MOVED" && parts[0] != "ASK") {
+		return redirect{}, false
+	}
+	slot, convErr := strconv.Atoi(parts[1])
+	if convErr != nil || slot < 0 || slot >= hashSlots {
+		return redirect{}, false
+	}
+	return redirect{ask: parts[0] == "ASK", slot: slot, address: parts[2]}, true
+}
+
+/**
+ * Runs the command build adds against the master owning key's slot,
+ * following MOVED and ASK replies up to MaxRedirects hops. An ASK
+ * target gets ASKING on the same connection right before the command.
+ * A MOVED reply means our slot map is stale, it is refreshed in the
+ * background while the command is retried on the node named.
+ */
+func (c *Client) do(ctx context.Context, name string, key string, build func(redis.Cmdable) redis.Cmder) error {
+	// the mapping is held for every hop, a refresh set off by a MOVED
+	// reply closes nothing this command still uses
+	cluster, _, release := c.acquire()
+	defer release()
+	hashSlot := HashSlot(key)
+
+	node := cluster.GetRedisClientAdapter(hashSlot)
+	if node == nil {
+		// a slot no master claimed, e.g. while it is being moved,
+		// any master redirects us to the owner
+		node = cluster.anyMaster()
+	}
+	if node == nil {
+		return errors.New("no node serves hash slot " + strconv.Itoa(hashSlot))
+	}
+
+	asking := false
+	for hop := 0; ; hop++ {
+		_, span := startSpan(ctx, "redis "+name, node.Options().Addr,
+			attribute.Int("redis.hash_slot", hashSlot),
+			attribute.Int("redis.redirects", hop),
+		)
+		err := run(ctx, node, asking, build)
+		endSpan(span, err)
+
+		r, ok := parseRedirect(err)
+		if !ok {
+			return err
+		}
+		if hop >= c.opts.MaxRedirects {
+			return errors.New("redis " + name + " " + key + ": too many redirects, last " + err.Error())
+		}
+		if !r.ask {
+			c.refreshSlots()
+		}
+		asking = r.ask
+
+		if node, err = cluster.connect(ctx, r.address, c.opts.Password, nil); err != nil {
+			return err
+		}
+		c.opts.Log(0, "Redis "+name+" slot "+strconv.Itoa(r.slot)+" redirected to "+r.address)
+	}
+}
+
+/**
+ * Sends the command, preceded by ASKING in one pipeline so
+ * both go over the same connection
+ */
+func run(ctx context.Context, node *redis.Client, asking bool, build func(redis.Cmdable) redis.Cmder) error {
+	node = node.WithContext(ctx)
+	if !asking {
+		return build(node).Err()
+	}
+
+	pipe := node.Pipeline()
+	pipe.Process(redis.NewStatusCmd("asking"))
+	cmd := build(pipe)
+	_, execErr := pipe.Exec()
+	if err := cmd.Err(); err != nil {
+		return err
+	}
+	if execErr != nil && execErr != redis.Nil {
+		return execErr
+	}
+	return nil
+}
+
+/**
+ * Rebuilds the slot map in the background, at most once per
+ * refreshInterval and never twice at the same time
+ */
+func (c *Client) refreshSlots() {
+	if !atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		return
+	}
+	last := atomic.LoadInt64(&c.lastRefresh)
+	if time.Since(time.Unix(0, last)) < refreshInterval {
+		atomic.StoreInt32(&c.refreshing, 0)
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		defer atomic.StoreInt64(&c.lastRefresh, time.Now().UnixNano())
+		if err := c.Reload(context.Background()); err != nil {
+			c.opts.Log(2, "Redis slot map refresh failed: "+err.Error())
+		}
+	}()
+}
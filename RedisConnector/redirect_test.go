@@ -0,0 +1,95 @@
+package RedisConnector
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseRedirect(t *testing.T) {
+	tests := []struct {
+		err  error
+		want redirect
+		ok   bool
+	}{
+		{errors.New("MOVED 3999 127.0.0.1:6381"), redirect{slot: 3999, address: "127.0.0.1:6381"}, true},
+		{errors.New("ASK 3999 127.0.0.1:6381"), redirect{ask: true, slot: 3999, address: "127.0.0.1:6381"}, true},
+		{errors.New("MOVED 0 [::1]:6381"), redirect{slot: 0, address: "[::1]:6381"}, true},
+		{nil, redirect{}, false},
+		{redis.Nil, redirect{}, false},
+		{errors.New("ERR wrong number of arguments"), redirect{}, false},
+		{errors.New("MOVED 3999"), redirect{}, false},
+		{errors.New("MOVED slot 127.0.0.1:6381"), redirect{}, false},
+		{errors.New("MOVED 16384 127.0.0.1:6381"), redirect{}, false},
+		{errors.New("MOVED -1 127.0.0.1:6381"), redirect{}, false},
+		{errors.New("TRYAGAIN 3999 127.0.0.1:6381"), redirect{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRedirect(tt.err)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseRedirect(%v) = %+v, %v, want %+v, %v", tt.err, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// redirectGet answers every GET with a kind ("MOVED" or "ASK") redirect
+// to the node at address
+func redirectGet(kind string, address string) func(args []string) (interface{}, bool) {
+	return func(args []string) (interface{}, bool) {
+		if args[0] != "GET" {
+			return nil, false
+		}
+		return replyError(kind + " " + strconv.Itoa(HashSlot(args[1])) + " " + address), true
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	const key = "alice"
+
+	tests := []struct {
+		name   string
+		kind   string
+		loop   bool // the target redirects back
+		want   string
+		err    string
+		gets   int // GETs received by both nodes
+		asking int
+	}{
+		{name: "MOVED is followed", kind: "MOVED", want: "value", gets: 2},
+		{name: "ASK is followed after ASKING", kind: "ASK", want: "value", gets: 2, asking: 1},
+		{name: "redirects stop at MaxRedirects", kind: "MOVED", loop: true, err: "too many redirects", gets: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := newFakeCluster(t)
+			client := newTestClient(t, a, Options{MaxRedirects: 3})
+			from := owner(key, a, b)
+			to := a
+			if from == a {
+				to = b
+			}
+			to.set(key, "value")
+			from.setHandle(redirectGet(tt.kind, to.addr))
+			if tt.loop {
+				to.setHandle(redirectGet(tt.kind, from.addr))
+			}
+
+			got, err := client.Get(context.Background(), key)
+			if tt.err == "" && (err != nil || got != tt.want) {
+				t.Errorf("get = %q, %v, want %q", got, err, tt.want)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("get error %v, want %q", err, tt.err)
+			}
+			if n := a.received("GET") + b.received("GET"); n != tt.gets {
+				t.Errorf("%d GETs sent, want %d", n, tt.gets)
+			}
+			if n := to.received("ASKING"); n != tt.asking {
+				t.Errorf("%d ASKING sent, want %d", n, tt.asking)
+			}
+		})
+	}
+}
@@ -1,23 +1,18 @@
 package RedisConnector
 
 import (
-	config "../Config"
-	Log "../Logger"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/davecgh/go-spew/spew"
 	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel/attribute"
 	"net"
-	"os"
-	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
-var ConfigData = config.Params{}
-
 const hashSlots = 16384
 
 /**
@@ -30,6 +25,10 @@ type PipelineData struct {
 	burstReady        bool
 	lastExecutionTime time.Time
 	currentNodeSize   uint64
+	burstSize         uint64
+	cluster           *ClusterScenario
+	log               func(level int, message string)
+	mu                sync.Mutex
 }
 
 /**
@@ -44,19 +43,35 @@ type ClusterScenario struct {
 	masterNodes map[string]*RedisMasterNode
 	slaveNodes  map[string]*RedisSlaveNode
 	mu          sync.Mutex
+
+	// commands and bursts running on this mapping, a reload
+	// closes the connections it drops once they are done
+	inUse sync.WaitGroup
+
+	// every client by node address, redirect targets outside the
+	// slot map included. Reused by the next mapping on a reload.
+	conns  map[string]*redis.Client
+	connMu sync.Mutex
+}
+
+/**
+ * An inclusive range of hash slots
+ */
+type slotRange struct {
+	start int
+	end   int
 }
 
 /**
  * Master node structure
  */
 type RedisMasterNode struct {
-	id            string
-	startHashSlot int
-	endHashSlot   int
-	address       string
-	host          string
-	port          string
-	client        *redis.Client
+	id      string
+	slots   []slotRange
+	address string
+	host    string
+	port    string
+	client  *redis.Client
 }
 
 /**
@@ -71,40 +86,6 @@ type RedisSlaveNode struct {
 	client  *redis.Client
 }
 
-/**
- * JSON Configuration Data structure
- */
-type ConfigurationData struct {
-	GossipClientPort string `json:"gossipClientPort"`
-	GossipClientHost string `json:"gossipClientHost"`
-}
-
-/**
- * Gossip Client. Initiates the node mappings and initial
- * cluster operations (CLUSTER MEET and CLUSTER REPLICATE)
- */
-var gossipClient *redis.Client
-
-/**
- * Read write lock for configuration handling
- */
-var configLock = new(sync.RWMutex)
-
-/**
- * Configuration Data structure
- */
-// var config *ConfigurationData
-
-/**
- * Cluster Scenario Structure
- */
-var clusterScenario *ClusterScenario
-
-/**
- * Pipeline Data
- */
-var p *PipelineData
-
 /**
  * CRC16 Mappings
  */
@@ -154,28 +135,47 @@ func (c *ClusterScenario) GetNodeSlotByHashSlot(key string) string {
 	var redisNodeId string
 	defer c.mu.Unlock()
 	for _, redisNode := range c.masterNodes {
-		if redisNode.startHashSlot <= hashSlot && redisNode.endHashSlot >= hashSlot {
+		if redisNode.serves(hashSlot) {
 			redisNodeId = redisNode.id
 		}
 	}
 	return redisNodeId
 }
 
+/**
+ * Starts pipeline data for the master nodes of cluster
+ */
+func newPipelineData(cluster *ClusterScenario, burstSize uint64, log func(level int, message string)) *PipelineData {
+	p := &PipelineData{
+		lastBurstResults:  make(map[string]int64, 0),
+		burstReady:        true,
+		lastExecutionTime: time.Now(),
+		burstSize:         burstSize,
+		cluster:           cluster,
+		log:               log,
+	}
+	p.clearNodeData()
+	return p
+}
+
 /**
  * Adds data to a node slot for future node pipeline bursting.
  * Updates the node size integer for fast access querying
  * in determination of ready bursting
  */
-func (p *PipelineData) addNodeData(key string) {
-	nodeSlot := clusterScenario.GetNodeSlotByHashSlot(key)
-	i := p.nodeData[nodeSlot][key]
-	i++
-	p.nodeData[nodeSlot][key] = i
-	j := p.currentNodeSize
-	j++
-	p.currentNodeSize = j
-	if p.currentNodeSize == 10 {
-		p.Burst()
+func (p *PipelineData) addNodeData(ctx context.Context, key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	nodeSlot := p.cluster.GetNodeSlotByHashSlot(key)
+	if nodeSlot == "" {
+		// no master claims the slot, its INCRBY would only be refused
+		p.log(2, "Redis pipeline dropped "+key+": no node serves hash slot "+strconv.Itoa(HashSlot(key)))
+		return
+	}
+	p.nodeData[nodeSlot][key]++
+	p.currentNodeSize++
+	if p.currentNodeSize >= p.burstSize {
+		p.burst(ctx)
 	}
 }
 
@@ -184,24 +184,43 @@ func (p *PipelineData) addNodeData(key string) {
  */
 func (p *PipelineData) clearNodeData() {
 	p.nodeData = make(map[string]map[string]int, 0)
-	for i, _ := range clusterScenario.masterNodes {
+	for i := range p.cluster.masterNodes {
 		p.nodeData[i] = make(map[string]int, 0)
 	}
 }
 
-func (p *PipelineData) Burst() {
+/**
+ * Sends the collected counts, traced as a child of the span in ctx
+ */
+func (p *PipelineData) Burst(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.burst(ctx)
+}
+
+func (p *PipelineData) burst(ctx context.Context) {
 	p.burstReady = false
+	ctx, burstSpan := tracer.Start(ctx, "redis pipeline burst")
+	defer burstSpan.End()
 	data := p.nodeData
 	result := map[string]*redis.IntCmd{}
 	for v := range data {
-		client := clusterScenario.GetConn(v)
+		if len(p.nodeData[v]) == 0 {
+			continue
+		}
+		client := p.cluster.GetConn(v)
+		observeBurst(client.Options().Addr, len(p.nodeData[v]))
+		_, span := startSpan(ctx, "redis pipeline", client.Options().Addr, attribute.Int("redis.pipeline.keys", len(p.nodeData[v])))
 		pipe := client.Pipeline()
 		for i, x := range p.nodeData[v] {
 			j := int64(x)
 			result[v+"."+i] = pipe.IncrBy(i, j)
 		}
 		_, err := pipe.Exec()
-		CheckErrorf(err, "Pipeline Issue")
+		endSpan(span, err)
+		if err != nil {
+			p.log(3, "Redis pipeline to "+client.Options().Addr+" failed: "+err.Error())
+		}
 	}
 	res2 := map[string]int64{}
 	for k, v := range result {
@@ -215,25 +234,12 @@ func (p *PipelineData) Burst() {
 }
 
 /**
- * Counts the number of failure reports for a specific node. Useful
- * in determining an agreed FAIL STATE by the cluster masters
- */
-func (c *ClusterScenario) CountNodeFailureReports(nodeSlot string) {
-
-}
-
-/**
- * Counts the number of keys in a hash slot
- */
-func (c *ClusterScenario) CountKeysInSlot(hashSlot int) {
-
-}
-
-/**
- * Returns cluster information
+ * Results of the last burst, keyed by node id and key
  */
-func (c *ClusterScenario) GetClusterInfo() {
-
+func (p *PipelineData) LastBurstResults() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastBurstResults
 }
 
 /**
@@ -255,14 +261,30 @@ func (rn *ClusterScenario) AddSlaveNode(id string, node *RedisSlaveNode) {
  */
 func (rn *RedisMasterNode) ToString(prefix string, full bool) string {
 	if full {
-		return fmt.Sprintf(prefix+" %s %s %d-%d",
-			rn.address, rn.id, rn.startHashSlot, rn.endHashSlot)
+		ranges := make([]string, len(rn.slots))
+		for i, r := range rn.slots {
+			ranges[i] = fmt.Sprintf("%d-%d", r.start, r.end)
+		}
+		return fmt.Sprintf(prefix+" %s %s %s",
+			rn.address, rn.id, strings.Join(ranges, ","))
 	} else {
 		return fmt.Sprintf(prefix+" %s %s",
 			rn.address, rn.id)
 	}
 }
 
+/**
+ * Reports whether hashSlot is in one of the node's slot ranges
+ */
+func (rn *RedisMasterNode) serves(hashSlot int) bool {
+	for _, r := range rn.slots {
+		if r.start <= hashSlot && hashSlot <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
 /**
  * Slave node to string
  */
@@ -272,58 +294,102 @@ func (rn *RedisSlaveNode) ToString(prefix string) string {
 }
 
 /**
- * Greet a master node. Performs a CLUSTER MEET command
- * against the node which was aggregated from the initiating
- * CLUSTER NODES parsing. This command gets called on initiation
- * of the script as well as when a node is MOVED as the build mappings
- * command gets recalled during that response. We only have to
- * execute this command once with the originating greeting client
+ * Returns a master node redis client
  */
-func (rn *RedisMasterNode) Greet(gossip *redis.Client) {
-	err := gossip.ClusterMeet(rn.host, rn.port).Err()
-	CheckErrorf(err, "Gossip Greet Error "+rn.address)
-	//fmt.Println(fmt.Sprintf("Greetings Master %s %s (HashSlots: %d-%d)",
-	//	rn.address, rn.id, rn.startHashSlot, rn.endHashSlot))
+func (rn *ClusterScenario) GetConn(id string) *redis.Client {
+	return rn.masterNodes[id].client
 }
 
 /**
- * Greet a slave node. Performs a CLUSTER MEET command
- * against the node which was aggregated from the initiating
- * CLUSTER NODES parsing. This command gets called on initiation
- * of the script as well as when a node is MOVED as the build mappings
- * command gets recalled during that response. We only have to
- * execute this command once with the originating greeting client
+ * Returns some master client, nil when there are none
  */
-func (rn *RedisSlaveNode) Greet(gossip *redis.Client) {
-	err := gossip.ClusterMeet(rn.host, rn.port).Err()
-	CheckErrorf(err, "Gossip Greet Error "+rn.address)
-	//fmt.Println(fmt.Sprintf("Greetings Slave %s %s (Master: %s)",
-	//	rn.address, rn.id, rn.master))
+func (rn *ClusterScenario) anyMaster() *redis.Client {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	for _, node := range rn.masterNodes {
+		return node.client
+	}
+	return nil
 }
 
 /**
- * Returns a master node redis client
+ * Pings every master node client, keyed by node address.
+ * Used by the readiness probe to report cluster reachability.
  */
-func (rn *ClusterScenario) GetConn(id string) *redis.Client {
-	return rn.masterNodes[id].client
+func (rn *ClusterScenario) PingNodes() map[string]error {
+	rn.mu.Lock()
+	nodes := make([]*RedisMasterNode, 0, len(rn.masterNodes))
+	for _, node := range rn.masterNodes {
+		nodes = append(nodes, node)
+	}
+	rn.mu.Unlock()
+
+	result := make(map[string]error, len(nodes))
+	for _, node := range nodes {
+		result[node.address] = node.client.Ping().Err()
+	}
+	return result
 }
 
 /**
- * Sends a CLUSTER REPLICATE command from a slave node.
+ * Returns the client for the node at address, taking it over
+ * from prev (may be nil) or connecting when there is none yet
  */
-func (rn *RedisSlaveNode) Replicate() {
-	rn.client.ClusterReplicate(rn.master)
-	//fmt.Println(fmt.Sprintf("Replicated Slave %s %s (Master: %s)",
-	//	rn.address, rn.id, rn.master))
+func (rn *ClusterScenario) connect(ctx context.Context, address string, password string, prev *ClusterScenario) (*redis.Client, error) {
+	rn.connMu.Lock()
+	defer rn.connMu.Unlock()
+	if client, ok := rn.conns[address]; ok {
+		return client, nil
+	}
+	if prev != nil {
+		prev.connMu.Lock()
+		client, ok := prev.conns[address]
+		prev.connMu.Unlock()
+		if ok {
+			rn.conns[address] = client
+			return client, nil
+		}
+	}
+	client, err := startRedisClient(ctx, address, password, 0)
+	if err != nil {
+		return nil, err
+	}
+	rn.conns[address] = client
+	return client, nil
 }
 
 /**
- * Error handling function
+ * Closes every node client
  */
-func CheckErrorf(err error, message string) {
-	if err != nil {
-		Log.LogMsg(5, message+" "+err.Error())
+func (rn *ClusterScenario) Close() error {
+	return rn.closeExcept(nil)
+}
+
+/**
+ * Closes the clients keep (may be nil) has not taken over
+ */
+func (rn *ClusterScenario) closeExcept(keep *ClusterScenario) error {
+	kept := map[*redis.Client]bool{}
+	if keep != nil {
+		keep.connMu.Lock()
+		for _, client := range keep.conns {
+			kept[client] = true
+		}
+		keep.connMu.Unlock()
+	}
+
+	rn.connMu.Lock()
+	defer rn.connMu.Unlock()
+	var firstErr error
+	for _, client := range rn.conns {
+		if kept[client] {
+			continue
+		}
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
 }
 
 /**
@@ -351,96 +417,15 @@ func HashSlot(key string) int {
 	return int(crc16(key) % hashSlots)
 }
 
-/**
- * Dump utility
- */
-func Dump(a ...interface{}) {
-	spew.Dump(a...)
-}
-
-/**
- * Handles query response. For Moved, we initiate a rebuild
- * of node mappings.
- */
-func HandleResponse(resp string) {
-	if strings.Contains(resp, "MOVED") {
-		//fmt.Println("MOVED RESPONSE")
-		//BuildNodeMappings()
-	} else if strings.Contains(resp, "OK") {
-		//fmt.Println("SUCCESS RESPONSE")
-	}
-}
-
-/**
- * Cluster Scenario perform redis get command wrapper
- */
-func (cs *ClusterScenario) DoGet(query string) interface{} {
-	return GetCmd(query)
-}
-
-/**
- * Cluster Scenario perform redis set command wrapper
- */
-func (cs *ClusterScenario) DoSet(query string, val interface{}) {
-	SetCmd(query, val)
-}
-
-func TestSetRedirect() {
-	client := GetRedisClientAdapter(5000)
-	val := client.Set("{customer}.name", "bar1", 0).String()
-	HandleResponse(val)
-}
-
-/**
- * Internal redis get cmd
- */
-func GetCmd(queryString string) interface{} {
-	hashSlot := HashSlot(queryString)
-	redisClient := GetRedisClientAdapter(hashSlot)
-	val, err := redisClient.Get(queryString).Result()
-	if err == redis.Nil {
-		CheckErrorf(err, fmt.Sprintf("Key %s Does Not Exist", queryString))
-	}
-	CheckErrorf(err, "Redis Get Error")
-	return val
-}
-
-///****
-//* Internal Redis command for zadd
-//*/
-//
-//func Zadd(key string, score string, member string) interface{}{
-//	hashSlot := HashSlot(key)
-//	redisClient := GetRedisClientAdapter(hashSlot)
-//	val , err := redisClient.ZAdd(key, score + " " + member)
-//	if err != nil {
-//		return err
-//	}
-//
-//	return val
-//
-//}
-
-/**
- * Internal redis set cmd
- */
-func SetCmd(queryString string, val interface{}) {
-	hashSlot := HashSlot(queryString)
-	redisClient := GetRedisClientAdapter(hashSlot)
-	resp := redisClient.Set(queryString, val, 0).String()
-	HandleResponse(resp)
-}
-
 /**
  * Get a master redis client based on hash slot which fetches
  * from node structure stored in cluster scenario
  */
-func GetRedisClientAdapter(hashSlot int) *redis.Client {
-	clusterScenario.mu.Lock()
-	defer clusterScenario.mu.Unlock()
-	for _, redisNode := range clusterScenario.masterNodes {
-		if redisNode.startHashSlot <= hashSlot && redisNode.endHashSlot >= hashSlot {
-			//fmt.Println(redisNode.ToString("Client Requested", false))
+func (rn *ClusterScenario) GetRedisClientAdapter(hashSlot int) *redis.Client {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	for _, redisNode := range rn.masterNodes {
+		if redisNode.serves(hashSlot) {
 			return redisNode.client
 		}
 	}
@@ -450,53 +435,83 @@ func GetRedisClientAdapter(hashSlot int) *redis.Client {
 /**
  * Start a redis client
  */
-func startRedisClient(address string, password string, db int) *redis.Client {
+func startRedisClient(ctx context.Context, address string, password string, db int) (*redis.Client, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     address,
 		Password: password,
 		DB:       db,
 	})
-	_, err := client.Ping().Result()
-	if err != nil {
-		// LogRedisConnError("Could not ping " + address, err)
-		Log.LogMsg(5, "Could not ping "+address+" "+err.Error())
+	instrumentClient(client, address)
+	if err := client.WithContext(ctx).Ping().Err(); err != nil {
+		client.Close()
+		return nil, errors.New("could not ping " + address + ": " + err.Error())
+	}
+	return client, nil
+}
+
+/**
+ * Parses the slot fields of a CLUSTER NODES line, "0-5460" or
+ * "5461". Slots being migrated, "[5461->-id]", or imported,
+ * "[5461-<-id]", are served by their current owner until the
+ * move completes and are skipped.
+ */
+func parseSlotRanges(fields []string) ([]slotRange, error) {
+	var ranges []slotRange
+	for _, field := range fields {
+		if field == "" || strings.HasPrefix(field, "[") {
+			continue
+		}
+		bounds := strings.SplitN(field, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, errors.New("bad hash slot " + field)
+		}
+		end := start
+		if len(bounds) > 1 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, errors.New("bad hash slot " + field)
+			}
+		}
+		if start < 0 || end < start || end >= hashSlots {
+			return nil, errors.New("bad hash slot " + field)
+		}
+		ranges = append(ranges, slotRange{start: start, end: end})
 	}
-	return client
+	return ranges, nil
 }
 
 /**
- * initiates the cluster scenario, zero values the master/slave node maps.
- * Starts a gossip client based on JSON config. we use this client to call
- * CLUSTER NODES and create our node mappings, including master and slave redis
- * clients. Then, we query CLUSTER MEET commands with the gossip client against
- * the populated mappings. This might be unuseful in initial mappings but for remappings
- * during a move this might be useful for fast initiation. We only have to query
- * each node once with the original gossip client. In the case of a remapping,
- * such as with a MOVE detect, we call this function which should then immediately
- * initiate an internal gossip. This code is functional, but the CLUSTER MEET has not been
- * thoroughly tested. The end goal would be to have a fast rebuild of the gossip
- * channel when a MOVE is detected. Also, i call CLUSTER REPLICATE on the
- * slave nodes, which might not be neccessaray however also for fast rebuild
- * would be needed.
+ * Builds the cluster scenario. Starts a gossip client on the configured
+ * host; we use this client to call CLUSTER NODES and create our node
+ * mappings, including master and slave redis clients. The cluster is
+ * only read, it is the administrator's to change. A master that cannot
+ * be reached fails the build. On a rebuild prev is the mapping being
+ * replaced, its connections are reused for nodes still in the cluster.
  */
-func BuildNodeMappings() {
-	// start cluster scenario
-	clusterScenario = &ClusterScenario{
+func buildNodeMappings(ctx context.Context, opts Options, prev *ClusterScenario) (*ClusterScenario, error) {
+	cs := &ClusterScenario{
 		masterNodes: make(map[string]*RedisMasterNode),
 		slaveNodes:  make(map[string]*RedisSlaveNode),
+		conns:       make(map[string]*redis.Client),
 	}
 
 	// load gossip master
-	gossipAddr := net.JoinHostPort(ConfigData.Redis.Host, ConfigData.Redis.Port)
-	gossipClient := startRedisClient(gossipAddr, "", 0)
+	gossipAddr := net.JoinHostPort(opts.Host, opts.Port)
+	gossipClient, err := cs.connect(ctx, gossipAddr, opts.Password, prev)
+	if err != nil {
+		return nil, err
+	}
 
 	// load node mapping hashes
 	masterNodes := make(map[int][]string, 0)
 	slaveNodes := make(map[int][]string, 0)
 
 	// translate nodes
-	nodes, err := gossipClient.ClusterNodes().Result()
-	CheckErrorf(err, "Cluster Nodes Error")
+	nodes, err := gossipClient.WithContext(ctx).ClusterNodes().Result()
+	if err != nil {
+		cs.closeExcept(prev)
+		return nil, errors.New("CLUSTER NODES failed: " + err.Error())
+	}
 	s := strings.Split(nodes, "\n")
 	var j, x int
 	for _, node := range s {
@@ -514,128 +529,71 @@ func BuildNodeMappings() {
 
 	// build redis master nodes
 	for _, n := range masterNodes {
+		if len(n) < 9 {
+			continue
+		}
 		nodeAddr := strings.Split(n[1], "@")[0]
-		slots := strings.Split(n[8], "-")
-		var nodeClient *redis.Client
-		if gossipAddr == nodeAddr {
-			nodeClient = gossipClient
-		} else {
-			nodeClient = startRedisClient(nodeAddr, "", 0)
+		slots, err := parseSlotRanges(n[8:])
+		if err != nil {
+			cs.closeExcept(prev)
+			return nil, errors.New(err.Error() + " for " + nodeAddr)
+		}
+		// a master without slots, e.g. one just added, serves no keys yet
+		if len(slots) == 0 {
+			continue
+		}
+		host, port, err := net.SplitHostPort(nodeAddr)
+		if err != nil {
+			cs.closeExcept(prev)
+			return nil, err
+		}
+		nodeClient, err := cs.connect(ctx, nodeAddr, opts.Password, prev)
+		if err != nil {
+			cs.closeExcept(prev)
+			return nil, err
 		}
-		startHashSlot, err := strconv.Atoi(slots[0])
-		CheckErrorf(err, "Convert Start Hash Slot Error")
-		endHashSlot, err := strconv.Atoi(slots[1])
-		CheckErrorf(err, "Convert End Hash Slot Error")
-		addrParts := strings.Split(nodeAddr, ":")
-		CheckErrorf(err, "Convert Port Error")
 		redisNode := RedisMasterNode{
-			id:            n[0],
-			client:        nodeClient,
-			startHashSlot: startHashSlot,
-			endHashSlot:   endHashSlot,
-			address:       nodeAddr,
-			host:          addrParts[0],
-			port:          addrParts[1],
+			id:      n[0],
+			client:  nodeClient,
+			slots:   slots,
+			address: nodeAddr,
+			host:    host,
+			port:    port,
 		}
-		redisNode.Greet(gossipClient)
-		clusterScenario.AddMasterNode(n[0], &redisNode)
+		cs.AddMasterNode(n[0], &redisNode)
 	}
 
 	// build redis slave nodes
 	for _, n := range slaveNodes {
+		if len(n) < 4 {
+			continue
+		}
 		nodeAddr := strings.Split(n[1], "@")[0]
-		nodeClient := startRedisClient(nodeAddr, "", 0)
-		addrParts := strings.Split(nodeAddr, ":")
-		CheckErrorf(err, "Convert Port Error")
+		host, port, err := net.SplitHostPort(nodeAddr)
+		if err != nil {
+			cs.closeExcept(prev)
+			return nil, err
+		}
+		nodeClient, err := cs.connect(ctx, nodeAddr, opts.Password, prev)
+		if err != nil {
+			// a missing replica does not stop reads and writes
+			opts.Log(2, "Redis replica skipped: "+err.Error())
+			continue
+		}
 		redisNode := RedisSlaveNode{
 			id:      n[0],
 			client:  nodeClient,
 			address: nodeAddr,
-			host:    addrParts[0],
-			port:    addrParts[1],
+			host:    host,
+			port:    port,
 			master:  n[3],
 		}
-		redisNode.Greet(gossipClient)
-		redisNode.Replicate()
-		clusterScenario.AddSlaveNode(n[0], &redisNode)
+		cs.AddSlaveNode(n[0], &redisNode)
 	}
 
-	// start pipeline data
-	p = &PipelineData{
-		nodeData:          make(map[string]map[string]int, 0),
-		lastBurstResults:  make(map[string]int64, 0),
-		burstReady:        true,
-		lastExecutionTime: time.Now(),
-		currentNodeSize:   0,
-	}
-	for i, _ := range clusterScenario.masterNodes {
-		p.nodeData[i] = make(map[string]int, 0)
+	if len(cs.masterNodes) == 0 {
+		cs.closeExcept(prev)
+		return nil, errors.New("no master nodes with hash slots at " + gossipAddr)
 	}
+	return cs, nil
 }
-
-/**
- * Initializes configuration data. Builds node mappings.
- * opens a USR2 signal which makes possible for
- * realtime reloading of configuration data and mappings
- * without restarting the program IF NEEDED.
- * You would call as:
- * killall -s USR2 {programname} (such as killall -s USR2 thisProgramName)
- */
-func init() {
-	// LoadConfig()
-	BuildNodeMappings()
-	s := make(chan os.Signal, 1)
-	signal.Notify(s, syscall.SIGUSR2)
-	go func() {
-		for {
-			<-s
-			// LoadConfig()
-			clusterScenario = nil
-			BuildNodeMappings()
-		}
-	}()
-}
-
-func AddRedisData(key string) {
-	p.addNodeData(key)
-}
-
-func FlushRedisPipelineData() {
-	p.Burst()
-}
-
-func FetchLastPipelineBurstResults() map[string]int64 {
-	return p.lastBurstResults
-}
-
-func GetRedisClientByKey(key string) *redis.Client {
-
-	return GetRedisClientAdapter(HashSlot(key))
-}
-
-/**
- * Pipeline test bank
- */
-
-/**
- * Standard test bank
- */
-//func StandardTest() {
-//	fmt.Println()
-//	hashSlotA := HashSlot("{customer}.name")
-//	fmt.Println("TEST MOVED DETECTED {customer}.name ===> HASH SLOT ", hashSlotA)
-//	TestSetRedirect()
-//	fmt.Println("*******************")
-//	fmt.Println()
-//	hashSlotB := HashSlot("{cust}.name")
-//	fmt.Println("TEST GET CLUSTER QUERY {cust}.name ===> HASH SLOT ", hashSlotB)
-//	val := clusterScenario.DoGet("{cust}.name")
-//	fmt.Println("VALUE => ", val)
-//	fmt.Println()
-//	hashSlotC := HashSlot("{custTEST}.name")
-//	fmt.Println("TEST SET CLUSTER QUERY {custTEST}.name WITH testdata ===> HASH SLOT ", hashSlotC)
-//	clusterScenario.DoSet("{custTEST}.name", "testdata")
-//	val = clusterScenario.DoGet("{custTEST}.name")
-//	fmt.Println("VALUE RETRIEVED ==> ", val)
-//	fmt.Println()
-//}
@@ -0,0 +1,38 @@
+package RedisConnector
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Spans go to the global tracer provider the application installs,
+ * a no-op until tracing is configured
+ */
+var tracer = otel.Tracer("RedisConnector")
+
+/**
+ * Starts a client span for a command sent to the node at address
+ */
+func startSpan(ctx context.Context, name string, address string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		attribute.String("db.system.name", "redis"),
+		attribute.String("server.address", address),
+	)
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+/**
+ * Ends span, marking it failed on any error but a missing key
+ */
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,31 @@
+\c contacts;
+
+create table address_books(
+ id uuid primary key default uuid_generate_v4(),
+ name text not null,
+ personal_owner uuid unique references users(id) on delete cascade,
+ created timestamptz not null default now()
+);
+
+create table address_book_members(
+ address_book_id uuid not null references address_books(id) on delete cascade,
+ user_id uuid not null references users(id) on delete cascade,
+ permission text not null default 'read' check (permission in ('read', 'write', 'admin')),
+ primary key (address_book_id, user_id)
+);
+
+alter table contacts add column address_book_id uuid references address_books(id) on delete cascade;
+
+-- existing contacts move into a shared book every current user can read
+insert into address_books (id, name) values ('00000000-0000-0000-0000-000000000001', 'Shared');
+
+update contacts set address_book_id = '00000000-0000-0000-0000-000000000001';
+
+alter table contacts alter column address_book_id set not null;
+
+create index contacts_address_book_idx on contacts(address_book_id);
+
+insert into address_book_members (address_book_id, user_id, permission)
+select '00000000-0000-0000-0000-000000000001', id,
+       case when role = 'admin' then 'admin' when role = 'editor' then 'write' else 'read' end
+from users;
@@ -0,0 +1,15 @@
+\c contacts;
+
+create table api_tokens(
+ id uuid primary key default uuid_generate_v4(),
+ user_id uuid not null references users(id) on delete cascade,
+ name text not null,
+ token_hash text not null unique,
+ scopes text[] not null,
+ expires timestamptz,
+ last_used timestamptz,
+ revoked timestamptz,
+ created timestamptz not null default now()
+);
+
+create index api_tokens_user_idx on api_tokens(user_id);
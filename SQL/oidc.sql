@@ -0,0 +1,6 @@
+\c contacts;
+
+alter table users add column oidc_issuer text;
+alter table users add column oidc_subject text;
+
+create unique index users_oidc_idx on users(oidc_issuer, oidc_subject);
@@ -0,0 +1,25 @@
+\c contacts;
+
+alter table users add column totp_secret text;
+alter table users add column totp_pending_secret text;
+alter table users add column totp_enabled bool not null default false;
+
+-- '' for a full session, 'verify' or 'enrol' while the second factor is outstanding
+alter table sessions add column mfa_pending text not null default '';
+
+create table recovery_codes(
+ id serial primary key,
+ user_id uuid not null references users(id) on delete cascade,
+ code_hash text not null,
+ used timestamptz
+);
+
+create index recovery_codes_user_idx on recovery_codes(user_id);
+
+create table settings(
+ key text primary key,
+ value text not null
+);
+
+-- '' for optional 2FA, 'all', or a comma separated list of roles
+insert into settings (key, value) values ('require_2fa', '');
@@ -0,0 +1,10 @@
+\c contacts;
+
+-- TOTP time step of the last accepted code, a code is only good once
+alter table users add column totp_last_step bigint not null default 0;
+
+-- failed second factor codes, reset by an accepted code. A user reaching the
+-- limit is locked out of 2FA until mfa_locked_until, a session is dropped.
+alter table users add column mfa_failures int not null default 0;
+alter table users add column mfa_locked_until timestamptz;
+alter table sessions add column mfa_failures int not null default 0;
@@ -0,0 +1,21 @@
+\c contacts;
+
+create table users(
+ id uuid primary key default uuid_generate_v4(),
+ username text not null unique,
+ password_hash text not null,
+ role text not null default 'viewer' check (role in ('admin', 'editor', 'viewer')),
+ enabled bool default true,
+ created timestamptz not null default now()
+);
+
+create table sessions(
+ token text primary key,
+ user_id uuid not null references users(id) on delete cascade,
+ expires timestamptz not null
+);
+
+create index sessions_expires_idx on sessions(expires);
+
+-- no account is seeded, create the first admin with
+--   contactmanager user add -role admin <username>
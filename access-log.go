@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clfTime is the timestamp layout of the Common Log Format
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog writes one line per request through ErrorHandler, so access lines
+// land in the same sinks as everything else. A common or combined line is
+// then the message of a text or json entry, only the json format is
+// structured there. With AccessLog.Path set those lines go to that file as
+// is instead, for tools that read CLF. Registered after RequestLogger
+// for the request ID and before Recovery so a recovered panic logs as a 500.
+// The AccessLog settings are read per request and follow config reloads.
+func (ac *appContext) AccessLog(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	conf := ac.Config().AccessLog
+	if conf.Format == "off" || skipAccessLog(conf.SkipPaths, c.Request.URL.Path) {
+		return
+	}
+
+	status := c.Writer.Status()
+	// failures are always logged, they are what the log is read for
+	if status < http.StatusBadRequest && sampledRoute(conf.SampleRoutes, c.FullPath()) && rand.Float64() >= conf.SampleRatio {
+		return
+	}
+
+	took := time.Since(start)
+	user := "-"
+	if u := CurrentUser(c); u != nil {
+		user = u.Username
+	}
+
+	if conf.Format == "json" {
+		ac.Log.WithContext(c).WithFields(logrus.Fields{
+			"path":        c.Request.URL.RequestURI(),
+			"proto":       c.Request.Proto,
+			"status":      status,
+			"bytes":       responseSize(c),
+			"duration_ms": math.Round(took.Seconds()*1e6) / 1e3,
+			"user":        user,
+			"referer":     c.Request.Referer(),
+			"user_agent":  c.Request.UserAgent(),
+		}).Msg(conf.Level, "Request")
+		return
+	}
+
+	bytes := "-"
+	if n := responseSize(c); n > 0 {
+		bytes = strconv.Itoa(n)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+		c.ClientIP(),
+		strings.Replace(user, " ", "_", -1),
+		start.Format(clfTime),
+		strconv.Quote(c.Request.Method+" "+c.Request.URL.RequestURI()+" "+c.Request.Proto),
+		status,
+		bytes,
+	)
+	if conf.Format == "combined" {
+		line += " " + clfQuote(c.Request.Referer()) + " " + clfQuote(c.Request.UserAgent())
+	}
+	if conf.Extended {
+		line += fmt.Sprintf(" %s %.3fms", RequestID(c), took.Seconds()*1e3)
+	}
+	ac.Log.Access(conf.Level, line)
+}
+
+// clfQuote quotes a client supplied header, escaping anything that could
+// forge a log line, and writes an empty one as "-"
+func clfQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+func skipAccessLog(prefixes []string, path string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func sampledRoute(routes []string, route string) bool {
+	for _, r := range routes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// responseSize is the body size written, gin reports -1 when nothing was
+func responseSize(c *gin.Context) int {
+	if n := c.Writer.Size(); n > 0 {
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// combinedLine is a Combined Log Format line followed by the Extended fields
+var combinedLine = regexp.MustCompile(`^\S+ - \S+ \[[^\]]+\] "GET /contacts\?q=1 HTTP/1\.1" 200 2 "https://ref\.example/" "agent/1\.0" \S+ [0-9.]+ms$`)
+
+func accessRouter(ac *appContext) *gin.Engine {
+	r := gin.New()
+	r.Use(ac.RequestLogger, ac.AccessLog)
+	r.GET("/contacts", func(c *gin.Context) {
+		c.String(200, "ok")
+	})
+	return r
+}
+
+func accessRequest(r *gin.Engine) {
+	req := httptest.NewRequest("GET", "/contacts?q=1", nil)
+	req.Header.Set("Referer", "https://ref.example/")
+	req.Header.Set("User-Agent", "agent/1.0")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestAccessLogFile(t *testing.T) {
+	ac, out := newTestContext()
+	path := filepath.Join(t.TempDir(), "access.log")
+	access, err := openAccessLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac.Log.access = access
+
+	accessRequest(accessRouter(ac))
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(written), "\n"), "\n")
+	if len(lines) != 1 || !combinedLine.MatchString(lines[0]) {
+		t.Errorf("access log is not combined format:\n%s", written)
+	}
+	if strings.Contains(out.String(), "/contacts") {
+		t.Errorf("access line also went to the sinks:\n%s", out.String())
+	}
+
+	// logrotate moved the file away
+	os.Rename(path, path+".1")
+	ac.Log.Reopen()
+	accessRequest(accessRouter(ac))
+	if written, _ := os.ReadFile(path); !combinedLine.MatchString(strings.TrimSuffix(string(written), "\n")) {
+		t.Errorf("reopened access log holds %q", written)
+	}
+}
+
+func TestAccessLogSinks(t *testing.T) {
+	ac, out := newTestContext()
+	conf := ac.Config()
+	conf.AccessLog.Format = "json"
+	conf.AccessLog.SkipPaths = nil
+	ac.config.Store(conf)
+
+	accessRequest(accessRouter(ac))
+
+	for _, field := range []string{`path="/contacts?q=1"`, "status=200", "bytes=2", `referer="https://ref.example/"`} {
+		if !strings.Contains(out.String(), field) {
+			t.Errorf("access entry lacks %s:\n%s", field, out.String())
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// sharedBookID is the book SQL/address_books.sql moved the original contacts into
+const sharedBookID = "00000000-0000-0000-0000-000000000001"
+
+const (
+	BookRead  = "read"
+	BookWrite = "write"
+	BookAdmin = "admin"
+)
+
+// bookPermissionRank orders membership permissions, higher includes lower
+var bookPermissionRank = map[string]int{
+	BookRead:  1,
+	BookWrite: 2,
+	BookAdmin: 3,
+}
+
+// bookLevelPermissions maps each membership level to the permission an API
+// token needs a scope for, so a read-only token cannot write to or manage a
+// book its owner administers
+var bookLevelPermissions = map[string]Permission{
+	BookRead:  PermContactRead,
+	BookWrite: PermContactWrite,
+	BookAdmin: PermContactWrite,
+}
+
+type AddressBook struct {
+	ID         string `sql:"id" json:"id"`
+	Name       string `sql:"name" json:"name"`
+	Personal   bool   `sql:"personal" json:"personal"`
+	Permission string `sql:"permission" json:"permission"`
+}
+
+type bookPostData struct {
+	Name string `form:"name" binding:"required"`
+}
+
+type memberPostData struct {
+	BookID     string `form:"bookID" binding:"required"`
+	Username   string `form:"username" binding:"required"`
+	Permission string `form:"permission"`
+}
+
+// CurrentBook returns the address book resolved by RequireBook
+func CurrentBook(c *gin.Context) *AddressBook {
+	if b, ok := c.Get("book"); ok {
+		return b.(*AddressBook)
+	}
+	return nil
+}
+
+// UserBooks lists the address books the user is a member of, personal book first
+func (ac *appContext) UserBooks(c *gin.Context, userID string) ([]AddressBook, error) {
+	query := `
+		select
+			b.id, b.name, b.personal_owner is not null, m.permission
+		from address_books b
+		join address_book_members m on m.address_book_id = b.id
+		where
+			m.user_id = $1
+		order by 3 desc, 2`
+
+	rows, err := ac.DB.QueryContext(ac.QueryCtx(c, "books.list"), query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []AddressBook{}
+	for rows.Next() {
+		var book AddressBook
+		if err := rows.Scan(&book.ID, &book.Name, &book.Personal, &book.Permission); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+// EnsurePersonalBook creates the user's private address book if it does not
+// exist yet. c may be nil outside a request.
+func (ac *appContext) EnsurePersonalBook(c *gin.Context, userID string, username string) error {
+	query := `
+		with book as (
+			insert into address_books (name, personal_owner)
+			values ($2, $1)
+			on conflict (personal_owner) do nothing
+			returning id
+		)
+		insert into address_book_members (address_book_id, user_id, permission)
+		select id, $1, 'admin' from book`
+
+	_, err := ac.DB.ExecContext(ac.QueryCtx(c, "books.ensure_personal"), query, userID, username+"'s contacts")
+	return err
+}
+
+// RequireBook resolves the address book named by the `book` query parameter or
+// `bookID` form field, falling back to the user's first book, and aborts with a
+// 403 unless the user's membership grants at least level and an API token
+// used for the request has a scope covering it.
+// Must be registered after RequireUser.
+func (ac *appContext) RequireBook(level string) gin.HandlerFunc {
+	return ac.RequireBookFor(level, bookLevelPermissions[level])
+}
+
+// RequireBookFor is RequireBook for a route whose token scope is that of
+// perm rather than of the membership level, such as the import.
+func (ac *appContext) RequireBookFor(level string, perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if !TokenAllows(c, perm) {
+			ac.AbortError(ErrForbidden.Because("token scope for address book permission "+level+" required"), c)
+			return
+		}
+
+		bookID := c.Query("book")
+		if bookID == "" {
+			bookID = c.PostForm("bookID")
+		}
+
+		book := &AddressBook{}
+		var query string
+		var err error
+		if bookID == "" {
+			query = `
+				select
+					b.id, b.name, b.personal_owner is not null, m.permission
+				from address_books b
+				join address_book_members m on m.address_book_id = b.id
+				where
+					m.user_id = $1
+				order by 3 desc, 2
+				limit 1`
+			err = ac.DB.QueryRowContext(ac.QueryCtx(c, "books.default"), query, user.ID).Scan(&book.ID, &book.Name, &book.Personal, &book.Permission)
+		} else {
+			query = `
+				select
+					b.id, b.name, b.personal_owner is not null, m.permission
+				from address_books b
+				join address_book_members m on m.address_book_id = b.id
+				where
+					m.user_id = $1
+					and b.id = $2`
+			err = ac.DB.QueryRowContext(ac.QueryCtx(c, "books.get"), query, user.ID, bookID).Scan(&book.ID, &book.Name, &book.Personal, &book.Permission)
+		}
+
+		if err == sql.ErrNoRows {
+			ac.AbortError(ErrBookForbidden.Because("no access to address book "+bookID), c)
+			return
+		}
+		if check := ac.DBErrorCheck(err, query, c); check == false {
+			return
+		}
+
+		// global admins manage every book they can see
+		if RoleHas(user.Role, PermAdmin) {
+			book.Permission = BookAdmin
+		}
+
+		if bookPermissionRank[book.Permission] < bookPermissionRank[level] {
+			ac.AbortError(ErrBookForbidden.Because("address book permission "+level+" required"), c)
+			return
+		}
+
+		c.Set("book", book)
+		ac.AddLogFields(c, logrus.Fields{"book_id": book.ID})
+		c.Next()
+	}
+}
+
+func (ac *appContext) listBooks(c *gin.Context) {
+	books, err := ac.UserBooks(c, CurrentUser(c).ID)
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"books": books})
+}
+
+func (ac *appContext) createBook(c *gin.Context) {
+	var form bookPostData
+
+	if err := c.ShouldBind(&form); err != nil {
+		ac.Log.WithContext(c).Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
+		return
+	}
+
+	query := `
+		with book as (
+			insert into address_books (name) values ($1) returning id
+		)
+		insert into address_book_members (address_book_id, user_id, permission)
+		select id, $2, 'admin' from book
+		returning address_book_id`
+
+	var bookID string
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "books.create"), query, form.Name, CurrentUser(c).ID).Scan(&bookID)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": bookID})
+}
+
+// setMember adds a user to the current book or changes their permission
+func (ac *appContext) setMember(c *gin.Context) {
+	var form memberPostData
+
+	if err := c.ShouldBind(&form); err != nil {
+		ac.Log.WithContext(c).Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
+		return
+	}
+	if form.Permission == "" {
+		form.Permission = BookRead
+	}
+	if _, ok := bookPermissionRank[form.Permission]; !ok {
+		ac.JSONError(http.StatusBadRequest, "unknown permission "+form.Permission, c)
+		return
+	}
+
+	book := CurrentBook(c)
+	if book.Personal {
+		ac.AbortError(ErrPersonalBook, c)
+		return
+	}
+
+	query := `
+		insert into address_book_members (address_book_id, user_id, permission)
+		select $1, id, $3 from users where username = $2
+		on conflict (address_book_id, user_id) do update set permission = excluded.permission`
+
+	res, err := ac.DB.ExecContext(ac.QueryCtx(c, "books.set_member"), query, book.ID, form.Username, form.Permission)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	if ra, _ := res.RowsAffected(); ra == 0 {
+		ac.AbortError(ErrUserNotFound.Because("no user "+form.Username), c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+func (ac *appContext) removeMember(c *gin.Context) {
+	var form memberPostData
+
+	if err := c.ShouldBind(&form); err != nil {
+		ac.Log.WithContext(c).Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
+		return
+	}
+
+	book := CurrentBook(c)
+	if book.Personal {
+		ac.AbortError(ErrPersonalBook, c)
+		return
+	}
+
+	query := `
+		delete from address_book_members
+		where
+			address_book_id = $1
+			and user_id = (select id from users where username = $2)`
+
+	res, err := ac.DB.ExecContext(ac.QueryCtx(c, "books.remove_member"), query, book.ID, form.Username)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	ra, _ := res.RowsAffected()
+	ac.Log.WithContext(c).Msg(0, fmt.Sprintf("rows affected [ %d ]", ra))
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
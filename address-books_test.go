@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql/driver"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+func TestRequireBook(t *testing.T) {
+	tests := []struct {
+		name       string
+		scopes     []string
+		membership string
+		level      string
+		want       int
+	}{
+		{"session reads", nil, BookRead, BookRead, http.StatusOK},
+		{"session reader manages members", nil, BookRead, BookAdmin, http.StatusForbidden},
+		{"session book admin manages members", nil, BookAdmin, BookAdmin, http.StatusOK},
+		{"read token reads", []string{ScopeContactsRead}, BookAdmin, BookRead, http.StatusOK},
+		{"read token writes", []string{ScopeContactsRead}, BookAdmin, BookWrite, http.StatusForbidden},
+		{"read token manages members", []string{ScopeContactsRead}, BookAdmin, BookAdmin, http.StatusForbidden},
+		{"write token manages members", []string{ScopeContactsWrite}, BookAdmin, BookAdmin, http.StatusOK},
+		{"admin token manages members", []string{ScopeAdmin}, BookAdmin, BookAdmin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		ac, _ := newTestContext()
+		membership := tt.membership
+		ac.DB = (&fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+			return fakeRows([]string{"id", "name", "personal", "permission"}, []driver.Value{"b1", "Team", false, membership})
+		}}).open()
+
+		r := gin.New()
+		r.POST("/", func(c *gin.Context) {
+			c.Set("user", &User{ID: "u1", Username: "alice", Role: RoleEditor})
+			if tt.scopes != nil {
+				c.Set("tokenScopes", tt.scopes)
+			}
+		}, ac.RequireBook(tt.level), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		if w := jsonRequest(r, "POST", "/", "bookID=b1"); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type rolePostData struct {
+	UserID string `form:"userID" binding:"required"`
+	Role   string `form:"role" binding:"required"`
+}
+
+func (ac *appContext) listUsers(c *gin.Context) {
+	query := `select id, username, role, enabled from users order by username`
+
+	rows, err := ac.DB.QueryContext(ac.QueryCtx(c, "users.list"), query)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.Enabled); err != nil {
+			ac.Log.WithContext(c).Msg(3, fmt.Sprintf("Error scanning row: %s", err.Error()))
+			continue
+		}
+		users = append(users, user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+func (ac *appContext) assignRole(c *gin.Context) {
+	var form rolePostData
+
+	if err := c.ShouldBind(&form); err != nil {
+		ac.Log.WithContext(c).Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
+		return
+	}
+	if !ValidRole(form.Role) {
+		ac.JSONError(http.StatusBadRequest, "unknown role "+form.Role, c)
+		return
+	}
+	if me := CurrentUser(c); me != nil && me.ID == form.UserID && form.Role != RoleAdmin {
+		ac.AbortError(ErrSelfDemotion, c)
+		return
+	}
+
+	query := `update users set role = $1 where id = $2`
+	res, err := ac.DB.ExecContext(ac.QueryCtx(c, "users.set_role"), query, form.Role, form.UserID)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	if ra, _ := res.RowsAffected(); ra == 0 {
+		ac.AbortError(ErrUserNotFound.Because("no user "+form.UserID), c)
+		return
+	}
+
+	ac.Log.WithContext(c).Msg(1, "Role of [ "+form.UserID+" ] set to [ "+form.Role+" ] by [ "+CurrentUser(c).Username+" ]")
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeContactsRead  = "contacts:read"
+	ScopeContactsWrite = "contacts:write"
+	ScopeImport        = "import"
+	ScopeAdmin         = "admin"
+
+	apiTokenPrefix = "cm_"
+)
+
+// permissionScopes maps each permission to the token scope that covers it,
+// the admin scope covers everything
+var permissionScopes = map[Permission]string{
+	PermContactRead:   ScopeContactsRead,
+	PermContactWrite:  ScopeContactsWrite,
+	PermContactDelete: ScopeContactsWrite,
+	PermContactImport: ScopeImport,
+	PermAdmin:         ScopeAdmin,
+}
+
+type APIToken struct {
+	ID       string     `sql:"id" json:"id"`
+	Name     string     `sql:"name" json:"name"`
+	Scopes   []string   `sql:"scopes" json:"scopes"`
+	Expires  *time.Time `sql:"expires" json:"expires"`
+	LastUsed *time.Time `sql:"last_used" json:"last_used"`
+	Revoked  *time.Time `sql:"revoked" json:"revoked"`
+	Created  time.Time  `sql:"created" json:"created"`
+}
+
+type tokenPostData struct {
+	Name        string   `form:"name" binding:"required"`
+	Scopes      []string `form:"scopes" binding:"required"`
+	ExpiresDays int      `form:"expiresDays"`
+}
+
+type revokePostData struct {
+	ID string `form:"tokenID" binding:"required"`
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenAllows reports whether the API token used for the request, if any,
+// carries a scope covering perm. Session requests are not limited by scopes.
+func TokenAllows(c *gin.Context, perm Permission) bool {
+	scopes, ok := c.Get("tokenScopes")
+	if !ok {
+		return true
+	}
+	for _, s := range scopes.([]string) {
+		if s == ScopeAdmin || s == permissionScopes[perm] {
+			return true
+		}
+	}
+	return false
+}
+
+// RejectAPIToken keeps account management to interactive sessions so a leaked
+// token cannot mint further tokens or weaken 2FA.
+// Must be registered after RequireUser.
+func (ac *appContext) RejectAPIToken(c *gin.Context) {
+	if _, ok := c.Get("tokenScopes"); ok {
+		ac.AbortError(ErrTokenNotAllowed, c)
+		return
+	}
+	c.Next()
+}
+
+// bearerToken returns the token from an `Authorization: Bearer` header
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), true
+}
+
+// loadAPIToken resolves a bearer token to its user and scopes and records
+// when it was last used
+func (ac *appContext) loadAPIToken(c *gin.Context, token string) (*User, []string, error) {
+	query := `
+		update api_tokens t set
+			last_used = now()
+		from users u
+		where
+			t.token_hash = $1
+			and t.user_id = u.id
+			and t.revoked is null
+			and (t.expires is null or t.expires > now())
+			and u.enabled
+		returning u.id, u.username, u.role, u.enabled, t.scopes`
+
+	user := &User{}
+	var scopes []string
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "tokens.authenticate"), query, hashAPIToken(token)).Scan(&user.ID, &user.Username, &user.Role, &user.Enabled, pq.Array(&scopes))
+	if err != nil && err != sql.ErrNoRows {
+		ac.DBErrorCheck(err, query, c)
+	}
+	return user, scopes, err
+}
+
+func (ac *appContext) listTokens(c *gin.Context) {
+	query := `
+		select
+			id, name, scopes, expires, last_used, revoked, created
+		from api_tokens
+		where
+			user_id = $1
+		order by created desc`
+
+	rows, err := ac.DB.QueryContext(ac.QueryCtx(c, "tokens.list"), query, CurrentUser(c).ID)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		err := rows.Scan(&t.ID, &t.Name, pq.Array(&t.Scopes), &t.Expires, &t.LastUsed, &t.Revoked, &t.Created)
+		if err != nil {
+			ac.Log.WithContext(c).Msg(3, fmt.Sprintf("Error scanning row: %s", err.Error()))
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// createToken issues a new token, the plain text value is only returned here
+func (ac *appContext) createToken(c *gin.Context) {
+	var form tokenPostData
+
+	if err := c.ShouldBind(&form); err != nil {
+		ac.Log.WithContext(c).Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
+		return
+	}
+
+	user := CurrentUser(c)
+	for _, scope := range form.Scopes {
+		allowed := false
+		for perm, s := range permissionScopes {
+			if s == scope && RoleHas(user.Role, perm) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			ac.AbortError(ErrScopeNotAllowed.Because("scope "+scope+" not available to role "+user.Role), c)
+			return
+		}
+	}
+
+	var expires *time.Time
+	if form.ExpiresDays > 0 {
+		t := time.Now().AddDate(0, 0, form.ExpiresDays)
+		expires = &t
+	}
+
+	secret, err := newSessionToken()
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	token := apiTokenPrefix + secret
+
+	query := `
+		insert into api_tokens (user_id, name, token_hash, scopes, expires)
+		values ($1, $2, $3, $4, $5)
+		returning id`
+
+	var tokenID string
+	err = ac.DB.QueryRowContext(ac.QueryCtx(c, "tokens.create"), query, user.ID, form.Name, hashAPIToken(token), pq.Array(form.Scopes), expires).Scan(&tokenID)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+
+	ac.Log.WithContext(c).Msg(1, "API token [ "+form.Name+" ] created for [ "+user.Username+" ]")
+	c.JSON(http.StatusOK, gin.H{
+		"id":      tokenID,
+		"token":   token,
+		"expires": expires,
+	})
+}
+
+func (ac *appContext) revokeToken(c *gin.Context) {
+	var form revokePostData
+
+	if err := c.ShouldBind(&form); err != nil {
+		ac.Log.WithContext(c).Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
+		return
+	}
+
+	query := `
+		update api_tokens set
+			revoked = now()
+		where
+			id = $1
+			and user_id = $2
+			and revoked is null`
+
+	res, err := ac.DB.ExecContext(ac.QueryCtx(c, "tokens.revoke"), query, form.ID, CurrentUser(c).ID)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	if ra, _ := res.RowsAffected(); ra == 0 {
+		ac.AbortError(ErrTokenNotFound.Because("no active token "+form.ID), c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
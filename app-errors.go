@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// AppError is an error with a stable code clients can match on, the HTTP
+// status it maps to and a message safe to show to users. The cause, when
+// there is one, is logged but only shown in Debug mode.
+type AppError struct {
+	Code    string
+	Status  int
+	Message string
+	Err     error
+}
+
+func NewAppError(code string, status int, message string) *AppError {
+	return &AppError{Code: code, Status: status, Message: message}
+}
+
+func (e *AppError) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches any AppError with the same code, so a wrapped catalog error
+// still satisfies errors.Is(err, ErrNotFound)
+func (e *AppError) Is(target error) bool {
+	t, ok := target.(*AppError)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of e caused by err
+func (e *AppError) Wrap(err error) *AppError {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// Because returns a copy of e with detail as its cause, for the log
+func (e *AppError) Because(detail string) *AppError {
+	return e.Wrap(errors.New(detail))
+}
+
+// The error catalog. Codes are part of the JSON API, do not rename them.
+var (
+	ErrBadRequest        = NewAppError("bad_request", http.StatusBadRequest, "The request could not be understood.")
+	ErrUnauthorized      = NewAppError("unauthorized", http.StatusUnauthorized, "Please log in to continue.")
+	ErrInvalidToken      = NewAppError("invalid_token", http.StatusUnauthorized, "The API token is invalid, expired or revoked.")
+	ErrForbidden         = NewAppError("forbidden", http.StatusForbidden, "You do not have permission to do that.")
+	ErrBookForbidden     = NewAppError("book_forbidden", http.StatusForbidden, "You do not have access to that address book.")
+	ErrPersonalBook      = NewAppError("personal_book", http.StatusForbidden, "Personal address books cannot be shared.")
+	ErrSelfDemotion      = NewAppError("self_demotion", http.StatusForbidden, "Admins cannot demote themselves.")
+	ErrTokenNotAllowed   = NewAppError("token_not_allowed", http.StatusForbidden, "This is not available to API tokens.")
+	ErrScopeNotAllowed   = NewAppError("scope_not_allowed", http.StatusForbidden, "Your role cannot grant that scope.")
+	ErrTwoFactorRequired = NewAppError("2fa_required", http.StatusForbidden, "Two-factor authentication is required for your role.")
+	ErrAccountNotAllowed = NewAppError("account_not_allowed", http.StatusForbidden, "This account is not allowed to sign in here.")
+	ErrNotFound          = NewAppError("not_found", http.StatusNotFound, "The page or record was not found.")
+	ErrUserNotFound      = NewAppError("user_not_found", http.StatusNotFound, "There is no such user.")
+	ErrTokenNotFound     = NewAppError("token_not_found", http.StatusNotFound, "There is no such active token.")
+	ErrSSODisabled       = NewAppError("sso_disabled", http.StatusNotFound, "Single sign-on is not configured.")
+	ErrAccountConflict   = NewAppError("account_conflict", http.StatusConflict, "An account with this email already exists, ask an admin to link it.")
+	ErrVerification      = NewAppError("verification_failed", http.StatusNotAcceptable, "Sign in could not be verified, please try again.")
+	ErrInternal          = NewAppError("internal", http.StatusInternalServerError, "Something went wrong on our side.")
+	ErrUnavailable       = NewAppError("unavailable", http.StatusServiceUnavailable, "The service is temporarily unavailable.")
+)
+
+// statusErrors is the catalog entry used for a bare status code
+var statusErrors = map[int]*AppError{
+	http.StatusBadRequest:          ErrBadRequest,
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusNotAcceptable:       ErrVerification,
+	http.StatusInternalServerError: ErrInternal,
+	http.StatusServiceUnavailable:  ErrUnavailable,
+}
+
+// errorTemplates names the page for a status, anything else gets errors/error
+var errorTemplates = map[int]string{
+	http.StatusBadRequest:          "errors/400",
+	http.StatusUnauthorized:        "errors/401",
+	http.StatusForbidden:           "errors/403",
+	http.StatusNotFound:            "errors/404",
+	http.StatusNotAcceptable:       "errors/verification",
+	http.StatusInternalServerError: "errors/500",
+}
+
+// statusError returns the catalog entry for status, or a generic one built
+// from the status text
+func statusError(status int) *AppError {
+	if e, ok := statusErrors[status]; ok {
+		return e
+	}
+	if status >= http.StatusInternalServerError {
+		return NewAppError("error", status, ErrInternal.Message)
+	}
+	return NewAppError("error", status, http.StatusText(status))
+}
+
+// asAppError returns err as an AppError, errors from outside the catalog
+// become status' catalog entry with err as the cause
+func asAppError(status int, err error) *AppError {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return statusError(status).Wrap(err)
+}
+
+func errorTemplate(status int) string {
+	if name, ok := errorTemplates[status]; ok {
+		return name
+	}
+	return "errors/error"
+}
@@ -33,6 +33,7 @@ function loadContact(ID) {
     console.log(ID);
     $.post("/editContact",{
         contactID: ID,
+        bookID: $("#bookID").val(),
     }).done(function(r){
         $("#contactID").val( r.ID );
         $("#firstName").val( r.FirstName );
@@ -68,6 +69,7 @@ function deleteContact(ID) {
     console.log('deleteContact()')
     $.post("/deleteContact", {
         contactID: ID,
+        bookID: $("#bookID").val(),
     }).done(function () {
         console.log('Contact Deleted');
         clearContact();
@@ -86,3 +88,7 @@ function deleteContact(ID) {
 
 
 
+
+function switchBook(ID) {
+    window.location = "/?book=" + encodeURIComponent(ID);
+}
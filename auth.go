@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"time"
+)
+
+const sessionCookie = "session"
+
+type User struct {
+	ID       string `sql:"id" json:"id"`
+	Username string `sql:"username" json:"username"`
+	Role     string `sql:"role" json:"role"`
+	Enabled  bool   `sql:"enabled" json:"enabled"`
+}
+
+type loginPostData struct {
+	Username string `form:"username" binding:"required"`
+	Password string `form:"password" binding:"required"`
+}
+
+// CurrentUser returns the user loaded by RequireUser, nil when nobody is logged in
+func CurrentUser(c *gin.Context) *User {
+	if u, ok := c.Get("user"); ok {
+		return u.(*User)
+	}
+	return nil
+}
+
+// dummyPasswordHash is compared against for an unknown username, so a failed
+// login takes as long whether or not the user exists
+const dummyPasswordHash = "$2a$10$9GBdDzUH6VPv/kWqhDma4uv9Rx5rpNM2JFLFVgClVVwMVlPHcr.Tq"
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// loadSession returns the user owning the session cookie and the second factor
+// still outstanding for that session, if any
+func (ac *appContext) loadSession(c *gin.Context) (*User, string, error) {
+	token, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return nil, "", sql.ErrNoRows
+	}
+
+	query := `
+		select
+			u.id, u.username, u.role, u.enabled, s.mfa_pending
+		from sessions s
+		join users u on u.id = s.user_id
+		where
+			s.token = $1
+			and s.expires > now()
+			and u.enabled
+	`
+	user := &User{}
+	var pending string
+	err = ac.DB.QueryRowContext(ac.QueryCtx(c, "sessions.load"), query, token).Scan(&user.ID, &user.Username, &user.Role, &user.Enabled, &pending)
+	if err != nil && err != sql.ErrNoRows {
+		ac.DBErrorCheck(err, query, c)
+	}
+	return user, pending, err
+}
+
+// RequireUser loads the user owning the bearer token or session cookie into
+// the gin context. Page loads without a valid session are sent to the login
+// page, sessions waiting on a second factor to the 2FA page.
+func (ac *appContext) RequireUser(c *gin.Context) {
+	if token, ok := bearerToken(c); ok {
+		user, scopes, err := ac.loadAPIToken(c, token)
+		if err == nil {
+			c.Set("user", user)
+			c.Set("tokenScopes", scopes)
+			ac.AddLogFields(c, logrus.Fields{"user": user.Username, "auth": "token"})
+			c.Next()
+		} else if err == sql.ErrNoRows {
+			ac.AbortError(ErrInvalidToken, c)
+		}
+		return
+	}
+
+	user, pending, err := ac.loadSession(c)
+	if err == nil && pending == "" {
+		c.Set("user", user)
+		ac.AddLogFields(c, logrus.Fields{"user": user.Username})
+		c.Next()
+		return
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+
+	if c.Request.Method == http.MethodGet {
+		if pending == mfaEnrol {
+			c.Redirect(http.StatusFound, "/account/2fa")
+		} else if pending == mfaVerify {
+			c.Redirect(http.StatusFound, "/login/2fa")
+		} else {
+			c.Redirect(http.StatusFound, "/login")
+		}
+		c.Abort()
+		return
+	}
+	ac.AbortError(ErrUnauthorized.Because("no valid session"), c)
+}
+
+// RequireSession is RequireUser for the 2FA pages, it also accepts sessions
+// still waiting on a second factor and stores that state as "mfaPending"
+func (ac *appContext) RequireSession(c *gin.Context) {
+	user, pending, err := ac.loadSession(c)
+	if err == nil {
+		c.Set("user", user)
+		c.Set("mfaPending", pending)
+		ac.AddLogFields(c, logrus.Fields{"user": user.Username})
+		c.Next()
+		return
+	}
+	if err != sql.ErrNoRows {
+		return
+	}
+
+	if c.Request.Method == http.MethodGet {
+		c.Redirect(http.StatusFound, "/login")
+		c.Abort()
+		return
+	}
+	ac.AbortError(ErrUnauthorized.Because("no valid session"), c)
+}
+
+func (ac *appContext) ShowLogin(c *gin.Context) {
+	c.HTML(http.StatusOK, "main/login", gin.H{"sso": ac.OIDC != nil})
+}
+
+func (ac *appContext) login(c *gin.Context) {
+	var form loginPostData
+
+	if err := c.ShouldBind(&form); err != nil {
+		c.HTML(http.StatusBadRequest, "main/login", gin.H{"error": "Username and password are required", "sso": ac.OIDC != nil})
+		return
+	}
+
+	var userID, hash string
+	query := `select id, password_hash from users where username = $1 and enabled`
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "users.login"), query, form.Username).Scan(&userID, &hash)
+	if err != nil && err != sql.ErrNoRows {
+		ac.DBErrorCheck(err, query, c)
+		return
+	}
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(form.Password))
+	} else {
+		// any bcrypt error counts as a mismatch, SSO users hold no usable hash
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(form.Password))
+	}
+	if err != nil {
+		ac.Log.WithContext(c).Msg(2, "Failed login for [ "+form.Username+" ]")
+		c.HTML(http.StatusUnauthorized, "main/login", gin.H{"error": "Invalid username or password", "sso": ac.OIDC != nil})
+		return
+	}
+
+	if err := ac.EnsurePersonalBook(c, userID, form.Username); err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+
+	pending, err := ac.pendingSecondFactor(c, userID)
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+
+	ac.startSession(c, userID, pending)
+}
+
+// startSession stores a new session for userID, sets the cookie and sends the
+// user home, or on to the 2FA page when pending names an outstanding factor
+func (ac *appContext) startSession(c *gin.Context, userID string, pending string) {
+	token, err := newSessionToken()
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+
+	ttl := time.Duration(ac.Config().SessionHours) * time.Hour
+	query := `insert into sessions (token, user_id, expires, mfa_pending) values ($1, $2, $3, $4)`
+	_, err = ac.DB.ExecContext(ac.QueryCtx(c, "sessions.create"), query, token, userID, time.Now().Add(ttl), pending)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+
+	ac.setCookie(c, sessionCookie, token, int(ttl.Seconds()), "/")
+	switch pending {
+	case mfaVerify:
+		c.Redirect(http.StatusFound, "/login/2fa")
+	case mfaEnrol:
+		c.Redirect(http.StatusFound, "/account/2fa")
+	default:
+		c.Redirect(http.StatusFound, "/")
+	}
+}
+
+// setCookie sets an HttpOnly, SameSite=Lax cookie, Secure when the request
+// came over TLS or SecureCookies says a proxy terminates it. Lax still sends
+// the OIDC flow cookie on the provider's redirect back.
+func (ac *appContext) setCookie(c *gin.Context, name string, value string, maxAge int, path string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, path, "", ac.Config().SecureCookies || c.Request.TLS != nil, true)
+}
+
+func (ac *appContext) logout(c *gin.Context) {
+	token, err := c.Cookie(sessionCookie)
+	ac.setCookie(c, sessionCookie, "", -1, "/")
+	if err == nil {
+		query := `delete from sessions where token = $1`
+		_, err = ac.DB.ExecContext(ac.QueryCtx(c, "sessions.delete"), query, token)
+		if check := ac.DBErrorCheck(err, query, c); check == false {
+			return
+		}
+	}
+	c.Redirect(http.StatusFound, "/login")
+}
+
+// SessionMaintenance removes expired sessions every SessionMaintenance hours
+func (ac *appContext) SessionMaintenance() {
+	if ac.Config().SessionMaintenance <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Duration(ac.Config().SessionMaintenance) * time.Hour) {
+			res, err := ac.DB.ExecContext(ac.QueryCtx(nil, "sessions.expire"), `delete from sessions where expires <= now()`)
+			if err != nil {
+				ac.Log.Msg(3, "Session clean up failed: "+err.Error())
+				continue
+			}
+			ra, _ := res.RowsAffected()
+			ac.Log.Msg(0, fmt.Sprintf("expired sessions removed [ %d ]", ra))
+		}
+	}()
+}
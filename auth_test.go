@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/tls"
+	"database/sql/driver"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		username      string
+		password      string
+		tls           bool
+		secureCookies bool
+		want          int
+		secure        bool
+	}{
+		{name: "plain request", username: "alice", password: "correct horse", want: http.StatusFound},
+		{name: "TLS request", username: "alice", password: "correct horse", tls: true, want: http.StatusFound, secure: true},
+		{name: "behind a TLS proxy", username: "alice", password: "correct horse", secureCookies: true, want: http.StatusFound, secure: true},
+		{name: "wrong password", username: "alice", password: "wrong", want: http.StatusUnauthorized},
+		{name: "unknown user", username: "mallory", password: "correct horse", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac, _ := newTestContext()
+			conf := ac.Config()
+			conf.SecureCookies = tt.secureCookies
+			ac.config.Store(conf)
+			ac.DB = (&fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+				switch {
+				case strings.HasPrefix(query, "select id, password_hash from users"):
+					if args[0] != "alice" {
+						return fakeRows([]string{"id", "password_hash"})
+					}
+					return fakeRows([]string{"id", "password_hash"}, []driver.Value{"u1", hash})
+				case strings.HasPrefix(query, "select role, totp_enabled from users"):
+					return fakeRows([]string{"role", "totp_enabled"}, []driver.Value{RoleViewer, false})
+				case strings.HasPrefix(query, "select value from settings"):
+					return fakeRows([]string{"value"})
+				case strings.HasPrefix(query, "with book as"),
+					strings.HasPrefix(query, "insert into sessions"):
+					return fakeResult{affected: 1}
+				}
+				return fakeResult{err: errors.New("unexpected query: " + query)}
+			}}).open()
+
+			r := gin.New()
+			r.SetHTMLTemplate(template.Must(template.New("main/login").Parse(`login {{.error}}`)))
+			r.POST("/login", ac.login)
+
+			req := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{"username": {tt.username}, "password": {tt.password}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want != http.StatusFound {
+				return
+			}
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Name != sessionCookie {
+				t.Fatalf("cookies %v", cookies)
+			}
+			cookie := cookies[0]
+			if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Secure != tt.secure {
+				t.Errorf("cookie HttpOnly %v SameSite %v Secure %v, want true Lax %v", cookie.HttpOnly, cookie.SameSite, cookie.Secure, tt.secure)
+			}
+		})
+	}
+}
+
+func TestDummyPasswordHash(t *testing.T) {
+	// an unknown username costs the same bcrypt work as a wrong password
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost %d, %v, want %d", cost, err, bcrypt.DefaultCost)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `usage: contactmanager [-config file] [-strict=false] [-Setting value ...] <command> [args]
+
+commands:
+  serve                           run the web server (default)
+  migrate                         apply pending SQL migrations, then
+                                  create the first admin with user add
+  import -user name [-book id] <file.csv>
+                                  import contacts from CSV as a user
+                                  whose role allows importing
+  export [-book id] [-format csv|json]
+                                  write contacts to stdout
+  user add [-role r] <username>   create a user, password read from stdin
+  user passwd <username>          set a password, read from stdin
+  user role <username> <role>     change a user's role
+  seed [-book id] [-count n]      insert generated contacts
+  config check                    validate and print the configuration
+
+Settings are named by their path in the config file, e.g. -ListenPort 4000
+or -SQL.Host db.internal.`
+
+// contactColumns is the CSV layout used by import and export
+var contactColumns = []string{"first_name", "last_name", "phone", "office_phone", "city", "state", "zip"}
+
+// ParseCommandLine splits args into the config file, the setting overrides
+// (kept so a reload applies them again) and the command with its arguments
+func ParseCommandLine(args []string) (string, []string, []string, error) {
+	scratch := DefaultParams()
+	fs := ParamsFlagSet(&scratch)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+
+	if err := fs.Parse(args); err != nil {
+		return "", nil, nil, err
+	}
+
+	command := fs.Args()
+	configArgs := args[:len(args)-len(command)]
+	if len(command) == 0 {
+		command = []string{"serve"}
+	}
+
+	return fs.Lookup("config").Value.String(), configArgs, command, nil
+}
+
+// RunCommand runs command and returns the process exit code
+func RunCommand(configFile string, configArgs []string, command []string) int {
+	switch command[0] {
+	case "config":
+		return cmdConfig(configFile, configArgs, command[1:])
+	case "serve", "migrate", "import", "export", "user", "seed":
+	default:
+		fmt.Fprintln(os.Stderr, "unknown command "+command[0]+"\n\n"+usage)
+		return 2
+	}
+
+	context, err := NewAppContext(configFile, configArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	if command[0] == "serve" {
+		// serve has logged why it stopped
+		if err := serve(context); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	if err := context.openDB(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer context.DB.Close()
+
+	return runDBCommand(context, command)
+}
+
+// runDBCommand runs a maintenance command against the open database and
+// returns the process exit code
+func runDBCommand(ac *appContext, command []string) int {
+	var err error
+	switch command[0] {
+	case "migrate":
+		err = cmdMigrate(ac)
+	case "import":
+		err = cmdImport(ac, command[1:])
+	case "export":
+		err = cmdExport(ac, command[1:])
+	case "user":
+		err = cmdUser(ac, command[1:])
+	case "seed":
+		err = cmdSeed(ac, command[1:])
+	default:
+		err = errors.New("unknown command " + command[0])
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+// openDB connects for a maintenance command, failing instead of falling back
+// to maintenance mode
+func (ac *appContext) openDB() error {
+	InitDB(ac)
+	if !ac.DBHealthy() {
+		return errors.New("database unreachable, see " + ac.Config().LogFile)
+	}
+	return nil
+}
+
+func cmdConfig(configFile string, configArgs []string, args []string) int {
+	if len(args) != 1 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	config, err := LoadConfig(configFile, configArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	out, _ := json.MarshalIndent(config.Redacted(), "", "  ")
+	fmt.Println(string(out))
+	fmt.Fprintln(os.Stderr, "configuration OK")
+	return 0
+}
+
+func cmdMigrate(ac *appContext) error {
+	done, err := ac.Migrate()
+	for _, name := range done {
+		fmt.Println("applied " + name)
+	}
+	if err == nil && len(done) == 0 {
+		fmt.Println("nothing to migrate")
+	}
+	if err != nil {
+		return err
+	}
+
+	// no account is seeded, the first admin is created from here too
+	var admins int
+	if err := ac.DB.QueryRow(`select count(*) from users where role = 'admin'`).Scan(&admins); err != nil {
+		return err
+	}
+	if admins == 0 {
+		fmt.Println("no admin yet, create one with: contactmanager user add -role admin <username>")
+	}
+	return nil
+}
+
+func cmdImport(ac *appContext, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	book := fs.String("book", sharedBookID, "address book ID to import into")
+	username := fs.String("user", "", "user the import is done as, their role must allow importing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *username == "" {
+		return errors.New("usage: import -user name [-book id] <file.csv>")
+	}
+
+	// the command line goes through the same role check as the web import
+	var role string
+	err := ac.DB.QueryRow(`select role from users where username = $1 and enabled`, *username).Scan(&role)
+	if err == sql.ErrNoRows {
+		return errors.New("no enabled user " + *username)
+	}
+	if err != nil {
+		return err
+	}
+	if !RoleHas(role, PermContactImport) {
+		return errors.New("role " + role + " of " + *username + " may not import contacts")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	count, err := ac.importCSV(nil, f, *book)
+	var appErr *AppError
+	if errors.As(err, &appErr) && appErr.Err != nil {
+		return appErr.Err
+	}
+	if err != nil {
+		return err
+	}
+	ac.Log.Msg(1, fmt.Sprintf("Imported [ %d ] contacts into [ %s ] as [ %s ]", count, *book, *username))
+	fmt.Printf("imported %d contacts\n", count)
+	return nil
+}
+
+func cmdExport(ac *appContext, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	book := fs.String("book", "", "address book ID, all books when empty")
+	format := fs.String("format", "csv", "csv or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "json" {
+		return errors.New("format must be csv or json")
+	}
+
+	query := `
+		select
+			id, first_name, last_name, phone, office_phone, city, state, zip, enabled
+		from contacts
+		where
+			($1 = '' or address_book_id::text = $1)
+			order by 3,2`
+
+	rows, err := ac.DB.Query(query, *book)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	contacts := []ContactInfo{}
+	for rows.Next() {
+		contact := NewContact()
+		err := rows.Scan(&contact.ID, &contact.FirstName, &contact.LastName, &contact.Phone, &contact.OfficePhone,
+			&contact.City, &contact.State, &contact.Zip, &contact.Enabled)
+		if err != nil {
+			return err
+		}
+		contacts = append(contacts, contact)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(contacts)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write(contactColumns)
+	for _, c := range contacts {
+		w.Write([]string{c.FirstName, c.LastName, strconv.Itoa(c.Phone), strconv.Itoa(c.OfficePhone),
+			c.City, c.State, c.Zip})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// readPassword reads one line from stdin so passwords can be piped in scripts
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if len(password) < 8 {
+		return "", errors.New("password must be at least 8 characters")
+	}
+	return password, nil
+}
+
+func cmdUser(ac *appContext, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: user add|passwd|role ...")
+	}
+
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+		role := fs.String("role", RoleViewer, "admin, editor or viewer")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: user add [-role r] <username>")
+		}
+		if !ValidRole(*role) {
+			return errors.New("unknown role " + *role)
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+
+		var userID string
+		query := `insert into users (username, password_hash, role) values ($1, $2, $3) returning id`
+		if err := ac.DB.QueryRow(query, fs.Arg(0), hash, *role).Scan(&userID); err != nil {
+			return err
+		}
+		if err := ac.EnsurePersonalBook(nil, userID, fs.Arg(0)); err != nil {
+			return err
+		}
+		ac.Log.Msg(1, "User [ "+fs.Arg(0)+" ] added with role [ "+*role+" ] from the command line")
+		fmt.Println("added " + fs.Arg(0) + " " + userID)
+
+	case "passwd":
+		if len(args) != 2 {
+			return errors.New("usage: user passwd <username>")
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+		if err := ac.execOne(`update users set password_hash = $1 where username = $2`, hash, args[1]); err != nil {
+			return err
+		}
+		// a new password ends every existing session
+		if _, err := ac.DB.Exec(`delete from sessions where user_id = (select id from users where username = $1)`, args[1]); err != nil {
+			return err
+		}
+		ac.Log.Msg(1, "Password of [ "+args[1]+" ] changed from the command line")
+		fmt.Println("password changed")
+
+	case "role":
+		if len(args) != 3 {
+			return errors.New("usage: user role <username> <role>")
+		}
+		if !ValidRole(args[2]) {
+			return errors.New("unknown role " + args[2])
+		}
+		if err := ac.execOne(`update users set role = $1 where username = $2`, args[2], args[1]); err != nil {
+			return err
+		}
+		ac.Log.Msg(1, "Role of [ "+args[1]+" ] set to [ "+args[2]+" ] from the command line")
+		fmt.Println("role changed")
+
+	default:
+		return errors.New("unknown user command " + args[0])
+	}
+	return nil
+}
+
+// execOne runs an update that must hit exactly one row
+func (ac *appContext) execOne(query string, args ...interface{}) error {
+	res, err := ac.DB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if ra, _ := res.RowsAffected(); ra != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+var (
+	seedFirstNames = []string{"Ada", "Alan", "Grace", "Linus", "Margaret", "Ken", "Barbara", "Dennis", "Frances", "John"}
+	seedLastNames  = []string{"Lovelace", "Turing", "Hopper", "Torvalds", "Hamilton", "Thompson", "Liskov", "Ritchie", "Allen", "Backus"}
+	seedCities     = [][2]string{{"Pittsburgh", "PA"}, {"Austin", "TX"}, {"Portland", "OR"}, {"Denver", "CO"}, {"Raleigh", "NC"}}
+)
+
+func cmdSeed(ac *appContext, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	book := fs.String("book", sharedBookID, "address book ID to seed")
+	count := fs.Int("count", 25, "number of contacts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	tx, err := ac.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `insert into contacts (first_name, last_name, phone, office_phone, city, state, zip, address_book_id)
+values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	for i := 0; i < *count; i++ {
+		city := seedCities[rnd.Intn(len(seedCities))]
+		_, err := tx.Exec(query,
+			seedFirstNames[rnd.Intn(len(seedFirstNames))],
+			seedLastNames[rnd.Intn(len(seedLastNames))],
+			5550000000+rnd.Int63n(10000),
+			5550000000+rnd.Int63n(10000),
+			city[0], city[1],
+			fmt.Sprintf("%05d", rnd.Intn(100000)),
+			*book)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("seeded %d contacts\n", *count)
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs fn with os.Stdout redirected and returns what it wrote
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&out, r)
+		close(done)
+	}()
+	fn()
+	w.Close()
+	<-done
+	return out.String()
+}
+
+// withStdin runs fn with input as os.Stdin
+func withStdin(t *testing.T, input string, fn func()) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(input), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+	fn()
+}
+
+func TestRunCommandUsage(t *testing.T) {
+	tests := []struct {
+		configArgs []string
+		command    []string
+		want       int
+	}{
+		{nil, []string{"frobnicate"}, 2},
+		{nil, []string{"config"}, 2},
+		{nil, []string{"config", "show"}, 2},
+		// SQL.User is required
+		{nil, []string{"config", "check"}, 1},
+		{[]string{"-SQL.User=test", "-ListenPort=99999"}, []string{"config", "check"}, 1},
+		{[]string{"-SQL.User=test"}, []string{"config", "check"}, 0},
+	}
+	for _, tt := range tests {
+		var code int
+		captureStdout(t, func() { code = RunCommand("", tt.configArgs, tt.command) })
+		if code != tt.want {
+			t.Errorf("%v %v exited %d, want %d", tt.configArgs, tt.command, code, tt.want)
+		}
+	}
+}
+
+func TestCmdUser(t *testing.T) {
+	tests := []struct {
+		command []string
+		stdin   string
+		want    int
+		ran     string
+	}{
+		{[]string{"user"}, "", 1, ""},
+		{[]string{"user", "remove", "alice"}, "", 1, ""},
+		{[]string{"user", "add"}, "correct horse", 1, ""},
+		{[]string{"user", "add", "-role", "owner", "bob"}, "correct horse", 1, ""},
+		{[]string{"user", "add", "-role", "editor", "bob"}, "short\n", 1, ""},
+		{[]string{"user", "add", "-role", "editor", "bob"}, "correct horse\n", 0, "insert into address_books"},
+		{[]string{"user", "passwd"}, "correct horse\n", 1, ""},
+		{[]string{"user", "passwd", "nobody"}, "correct horse\n", 1, "update users set password_hash"},
+		{[]string{"user", "passwd", "alice"}, "correct horse\n", 0, "delete from sessions"},
+		{[]string{"user", "role", "alice"}, "", 1, ""},
+		{[]string{"user", "role", "alice", "owner"}, "", 1, ""},
+		{[]string{"user", "role", "nobody", "admin"}, "", 1, "update users set role"},
+		{[]string{"user", "role", "alice", "admin"}, "", 0, "update users set role"},
+	}
+	for _, tt := range tests {
+		ac, _ := newTestContext()
+		db := &fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+			switch {
+			case strings.HasPrefix(query, "insert into users"):
+				if args[0] != "bob" || args[2] != RoleEditor {
+					return fakeResult{err: errors.New("unexpected user")}
+				}
+				return fakeRows([]string{"id"}, []driver.Value{"u2"})
+			case strings.HasPrefix(query, "with book as"):
+				return fakeResult{affected: 1}
+			case strings.HasPrefix(query, "update users set"):
+				if args[1] == "alice" {
+					return fakeResult{affected: 1}
+				}
+				return fakeResult{}
+			case strings.HasPrefix(query, "delete from sessions"):
+				return fakeResult{affected: 2}
+			}
+			return fakeResult{err: errors.New("unexpected query: " + query)}
+		}}
+		ac.DB = db.open()
+
+		var code int
+		withStdin(t, tt.stdin, func() {
+			captureStdout(t, func() { code = runDBCommand(ac, tt.command) })
+		})
+		if code != tt.want {
+			t.Errorf("%v exited %d, want %d", tt.command, code, tt.want)
+		}
+		if tt.ran != "" && !db.ran(tt.ran) {
+			t.Errorf("%v did not run %q", tt.command, tt.ran)
+		}
+		if tt.ran == "" && len(db.queries) > 0 {
+			t.Errorf("%v ran %q after a usage error", tt.command, db.queries)
+		}
+	}
+}
+
+func TestCmdSeed(t *testing.T) {
+	ac, _ := newTestContext()
+	var inserted int
+	ac.DB = (&fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "insert into contacts") && args[7] == "b1" {
+			inserted++
+			return fakeResult{affected: 1}
+		}
+		return fakeResult{err: errors.New("unexpected query: " + query)}
+	}}).open()
+
+	if code := runDBCommand(ac, []string{"seed", "-count", "many"}); code != 1 {
+		t.Errorf("seed -count many exited %d, want 1", code)
+	}
+	var code int
+	out := captureStdout(t, func() { code = runDBCommand(ac, []string{"seed", "-book", "b1", "-count", "3"}) })
+	if code != 0 || inserted != 3 || !strings.Contains(out, "seeded 3 contacts") {
+		t.Errorf("seed exited %d after %d inserts, printed %q", code, inserted, out)
+	}
+}
+
+func TestCmdMigrateFirstAdmin(t *testing.T) {
+	for _, admins := range []int64{0, 1} {
+		ac, _ := newTestContext()
+		ac.DB = (&fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+			switch {
+			case strings.HasPrefix(query, "create table if not exists schema_migrations"):
+				return fakeResult{}
+			case strings.HasPrefix(query, "select name from schema_migrations"):
+				rows := fakeRows([]string{"name"})
+				for _, name := range migrations {
+					rows.rows = append(rows.rows, []driver.Value{name})
+				}
+				return rows
+			case strings.HasPrefix(query, "select count(*) from users where role = 'admin'"):
+				return fakeRows([]string{"count"}, []driver.Value{admins})
+			}
+			return fakeResult{err: errors.New("unexpected query: " + query)}
+		}}).open()
+
+		var err error
+		out := captureStdout(t, func() { err = cmdMigrate(ac) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hinted := strings.Contains(out, "user add -role admin"); hinted != (admins == 0) {
+			t.Errorf("%d admins: migrate printed %q", admins, out)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// restartSettings are applied once at start up. A reload keeps their running
+// values and logs that a restart is needed.
+var restartSettings = []string{
+	"LogFile",
+	"AccessLog.Path",
+	"ListenIP",
+	"ListenPort",
+	"SessionMaintenance",
+	"ConfigWatchSeconds",
+	"Redis",
+	"SQL",
+	"OIDC",
+	"Tracing",
+}
+
+func needsRestart(path []string) bool {
+	joined := strings.Join(path, ".")
+	for _, s := range restartSettings {
+		if joined == s || strings.HasPrefix(joined, s+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// keepRestartSettings copies every restart only setting from prev into next
+// and returns the names of those that differed
+func keepRestartSettings(prev Params, next *Params) []string {
+	var changed []string
+	prevFields := map[string]reflect.Value{}
+
+	walkParams(reflect.ValueOf(&prev).Elem(), nil, func(path []string, field reflect.Value) {
+		prevFields[strings.Join(path, ".")] = field
+	})
+	walkParams(reflect.ValueOf(next).Elem(), nil, func(path []string, field reflect.Value) {
+		if !needsRestart(path) {
+			return
+		}
+		old := prevFields[strings.Join(path, ".")]
+		if !reflect.DeepEqual(old.Interface(), field.Interface()) {
+			changed = append(changed, strings.Join(path, "."))
+			field.Set(old)
+		}
+	})
+
+	return append(changed, keepSinkDestinations(prev.LogSinks, &next.LogSinks)...)
+}
+
+// keepSinkDestinations does for LogSinks what keepRestartSettings does for
+// whole settings: sinks are opened at start up, so only their Level and Format
+// are taken from next. Adding or removing a sink needs a restart too.
+func keepSinkDestinations(prev []LogSink, next *[]LogSink) []string {
+	if len(prev) != len(*next) {
+		*next = prev
+		return []string{"LogSinks"}
+	}
+
+	var changed []string
+	for i := range *next {
+		old, field := reflect.ValueOf(prev[i]), reflect.ValueOf(&(*next)[i]).Elem()
+		for j := 0; j < field.NumField(); j++ {
+			name := field.Type().Field(j).Name
+			if name == "Level" || name == "Format" {
+				continue
+			}
+			if !reflect.DeepEqual(old.Field(j).Interface(), field.Field(j).Interface()) {
+				changed = append(changed, fmt.Sprintf("LogSinks[%d].%s", i, name))
+				field.Field(j).Set(old.Field(j))
+			}
+		}
+	}
+	return changed
+}
+
+// ReloadConfig re-reads the configuration, swaps it in if valid and applies
+// the logger and Slack settings. Everything read through Config() at use,
+// such as SessionHours, Debug and AccessLog, follows the new values straight
+// away, restartSettings keep their running values.
+func (ac *appContext) ReloadConfig() error {
+	next, err := LoadConfig(ac.configFile, ac.configArgs)
+	if err != nil {
+		ac.Log.Msg(3, "Config reload rejected: "+err.Error())
+		return err
+	}
+
+	for _, name := range keepRestartSettings(ac.Config(), &next) {
+		ac.Log.Msg(2, "Config reload: "+name+" changed, restart to apply")
+	}
+
+	ac.config.Store(next)
+	ac.Log.ApplyConfig(&next)
+
+	ac.Log.Msg(1, "Configuration reloaded from [ "+ac.configFile+" ]")
+	return nil
+}
+
+// WatchConfig reloads the configuration on SIGHUP and, when ConfigWatchSeconds
+// is set, whenever the config file's modification time changes
+func (ac *appContext) WatchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var poll <-chan time.Time
+	var lastMod time.Time
+	if seconds := ac.Config().ConfigWatchSeconds; seconds > 0 && ac.configFile != "" {
+		poll = time.Tick(time.Duration(seconds) * time.Second)
+		if info, err := os.Stat(ac.configFile); err == nil {
+			lastMod = info.ModTime()
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+				ac.Log.Msg(1, "SIGHUP received, reloading configuration")
+				_ = ac.ReloadConfig()
+			case <-poll:
+				info, err := os.Stat(ac.configFile)
+				if err != nil || info.ModTime().Equal(lastMod) {
+					continue
+				}
+				lastMod = info.ModTime()
+				ac.Log.Msg(1, "Config file changed, reloading configuration")
+				_ = ac.ReloadConfig()
+			}
+		}
+	}()
+}
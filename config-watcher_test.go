@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestKeepRestartSettingsLogSinks(t *testing.T) {
+	prev := DefaultParams()
+	prev.LogSinks = []LogSink{
+		{Type: "file", Path: "/var/log/cm.log", Level: intPtr(1), Format: "text"},
+		{Type: "syslog", Network: "udp", Address: "loghost:514"},
+	}
+
+	next := prev
+	next.LogSinks = []LogSink{
+		{Type: "file", Path: "/var/log/cm.log", Level: intPtr(0), Format: "json"},
+		{Type: "syslog", Network: "tcp", Address: "loghost:514", Level: intPtr(3)},
+	}
+	next.LogLevel = 2
+
+	changed := keepRestartSettings(prev, &next)
+	if want := []string{"LogSinks[1].Network"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed %v, want %v", changed, want)
+	}
+	if *next.LogSinks[0].Level != 0 || next.LogSinks[0].Format != "json" || *next.LogSinks[1].Level != 3 {
+		t.Error("sink Level and Format were not taken from the reload")
+	}
+	if next.LogSinks[1].Network != "udp" {
+		t.Errorf("sink Network %q, want the running udp", next.LogSinks[1].Network)
+	}
+	if next.LogLevel != 2 {
+		t.Error("LogLevel was not taken from the reload")
+	}
+
+	added := prev
+	added.LogSinks = append(append([]LogSink{}, prev.LogSinks...), LogSink{Type: "stderr"})
+	if changed := keepRestartSettings(prev, &added); !reflect.DeepEqual(changed, []string{"LogSinks"}) {
+		t.Errorf("changed %v, want [LogSinks]", changed)
+	}
+	if len(added.LogSinks) != 2 {
+		t.Errorf("%d sinks after adding one on reload, want the running 2", len(added.LogSinks))
+	}
+}
+
+func TestApplyConfigSinkLevels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cm.log")
+	conf := DefaultParams()
+	conf.LogLevel = 2
+	conf.LogSinks = []LogSink{{Type: "file", Path: path}}
+
+	var e ErrorHandler
+	e.InitLog(&conf)
+	e.Msg(1, "info before reload")
+
+	conf.LogLevel = 1
+	e.ApplyConfig(&conf)
+	e.Msg(1, "info after LogLevel reload")
+
+	conf.LogSinks[0].Level = intPtr(2)
+	conf.LogSinks[0].Format = "json"
+	e.ApplyConfig(&conf)
+	e.Msg(1, "info after sink Level reload")
+	e.Msg(2, "warning as json")
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(out)
+	if strings.Contains(log, "info before reload") || strings.Contains(log, "info after sink Level reload") {
+		t.Errorf("messages below the sink level were logged:\n%s", log)
+	}
+	if !strings.Contains(log, "info after LogLevel reload") {
+		t.Errorf("a sink without Level does not follow LogLevel:\n%s", log)
+	}
+	if !strings.Contains(log, `"msg":"warning as json"`) {
+		t.Errorf("the sink Format was not applied:\n%s", log)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	ac, out := newTestContext()
+	ac.configFile = filepath.Join(t.TempDir(), "config.json")
+	write := func(doc string) {
+		if err := os.WriteFile(ac.configFile, []byte(doc), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"ListenPort": "3000", "SessionHours": 1, "SQL": {"Host": "db1", "User": "test"}}`)
+	running, err := LoadConfig(ac.configFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac.config.Store(running)
+
+	write(`{"ListenPort": "4000", "SessionHours": 8, "Debug": 1, "SQL": {"Host": "db2", "User": "test"}}`)
+	if err := ac.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	conf := ac.Config()
+	if conf.ListenPort != "3000" || conf.SQL.Host != "db1" {
+		t.Errorf("restart settings changed on reload: ListenPort %s, SQL.Host %s", conf.ListenPort, conf.SQL.Host)
+	}
+	if conf.SessionHours != 8 || conf.Debug != 1 {
+		t.Errorf("live settings not applied: SessionHours %d, Debug %d", conf.SessionHours, conf.Debug)
+	}
+	for _, name := range []string{"ListenPort", "SQL"} {
+		if !strings.Contains(out.String(), "Config reload: "+name) {
+			t.Errorf("no restart warning for %s:\n%s", name, out.String())
+		}
+	}
+
+	// an invalid file keeps the running configuration
+	write(`{"SessionHours": "many"}`)
+	if err := ac.ReloadConfig(); err == nil {
+		t.Error("an invalid config was accepted")
+	}
+	if ac.Config().SessionHours != 8 {
+		t.Error("a rejected reload changed the configuration")
+	}
+}
@@ -1,64 +1,613 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
-	log "github.com/sirupsen/logrus"
+	"flag"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
+// EnvPrefix is prepended to the upper cased json path of a Params field to
+// name its environment override, e.g. CONTACTMANAGER_SQL_PASSWORD
+const EnvPrefix = "CONTACTMANAGER_"
+
+// LogSink is one log destination with its own level and format. The file
+// settings only apply to file sinks, the network ones to syslog. Level and
+// Format follow a reload, the rest needs a restart.
+type LogSink struct {
+	Type       string `json:"Type"`       // file, stderr or syslog
+	Level      *int   `json:"Level"`      // min level, -1 (trace) to 5 (panic), LogLevel when omitted
+	Format     string `json:"Format"`     // text or json, LogFormat when omitted
+	Path       string `json:"Path"`       // log file
+	MaxSizeMB  int    `json:"MaxSizeMB"`  // rotate when the file reaches this size, 0 leaves rotation to logrotate
+	MaxAgeDays int    `json:"MaxAgeDays"` // remove rotated files older than this, 0 keeps them
+	MaxBackups int    `json:"MaxBackups"` // rotated files to keep, 0 keeps all
+	Compress   bool   `json:"Compress"`   // gzip rotated files
+	Network    string `json:"Network"`    // unix, unixgram, udp or tcp, empty for the local syslog daemon
+	Address    string `json:"Address"`    // socket path or host:port
+	Tag        string `json:"Tag"`        // syslog program name
+}
+
 type Params struct {
-	Debug              int     `json:"Debug"`       // enable extra debugging beyond log level
-	LogFile            string  `json:"LogFile"`     // log file
-	LogFormat          string  `json:"LogFormat"`   // text or json
-	LogLevel           int     `json:"LogLevel"`    // Min log level to log to file
-	APIkey             string  `json:"APIkey"`      // API key for advanced insight switch API
-	ListenIP           string  `json:"ListenIP"`    // IP to listen on
-	ListenPort         string  `json:"ListenPort"`  // Port to bind to
-	EpochWindow        int     `json:"EpochWindow"` // range of secs for allowing an api query
-	DefaultRatePerUser float32 `json:"DefaultRatePerUser"`
-	SessionHours       int     `json:"SessionHours"`             // how long a session should last
-	SessionMaintenance int     `json:"SessionMaintenance"`       // interval of hours to run session clean up
-	SlackChannel       string  `json:"SlackChannel"`             // where to alarm to
-	SlackHook          string  `json:"SlackHook"`                // slack hook URI
-	MaxCallsEscalate   int64   `json:"MaxCallReportsToEscalate"` // how many before triggering an escalation with the switch API
+	Debug              int       `json:"Debug"`       // enable extra debugging beyond log level
+	LogFile            string    `json:"LogFile"`     // log file
+	LogFormat          string    `json:"LogFormat"`   // text or json
+	LogLevel           int       `json:"LogLevel"`    // Min log level to log to file
+	LogSinks           []LogSink `json:"LogSinks"`    // replaces LogFile when set
+	APIkey             string    `json:"APIkey"`      // API key for advanced insight switch API
+	ListenIP           string    `json:"ListenIP"`    // IP to listen on
+	ListenPort         string    `json:"ListenPort"`  // Port to bind to
+	EpochWindow        int       `json:"EpochWindow"` // range of secs for allowing an api query
+	DefaultRatePerUser float32   `json:"DefaultRatePerUser"`
+	SessionHours       int       `json:"SessionHours"`              // how long a session should last
+	SecureCookies      bool      `json:"SecureCookies"`             // mark cookies Secure behind a TLS terminating proxy, always done on a TLS request
+	SessionMaintenance int       `json:"SessionMaintenance"`        // interval of hours to run session clean up
+	SlackChannel       string    `json:"SlackChannel"`              // where to alarm to
+	SlackHook          string    `json:"SlackHook"`                 // slack hook URI
+	SlackAlertInterval int       `json:"SlackAlertIntervalSeconds"` // minimum secs between alerts for the same error
+	MaxCallsEscalate   int64     `json:"MaxCallReportsToEscalate"`  // how many before triggering an escalation with the switch API
+	ConfigWatchSeconds int       `json:"ConfigWatchSeconds"`        // poll the config file for changes, 0 reloads on SIGHUP only
 	SMS                struct {
 		Secret string `json:"Secret"` // set in telnyx portal
 		URL    string `json:"URL"`    // endpoint for outbound messaging
 		From   string `json:"From"`   // originating DID from telnyx
 	} `json:"SMS"`
 	Redis struct {
-		Host string `json:"Host"` // redis host
-		Port string `json:"Port"` // redis port
-		Size string `json:"Size"` // redis cluster size
+		Enabled  bool   `json:"Enabled"`  // connect to the cluster at start up
+		Host     string `json:"Host"`     // redis host
+		Port     string `json:"Port"`     // redis port
+		Password string `json:"Password"` // redis AUTH password
+		Size     string `json:"Size"`     // redis cluster size
 	} `json:"Redis"`
 	SQL struct {
-		Host     string `json:"Host"`     // pgsql host
-		Port     string `json:"Port"`     // pgsql port
-		DBname   string `json:"DBname"`   // pgsql database name
-		User     string `json:"User"`     // pgsql user
-		Password string `json:"Password"` // pgsql pwd
+		Host                   string `json:"Host"`                   // pgsql host
+		Port                   string `json:"Port"`                   // pgsql port
+		DBname                 string `json:"DBname"`                 // pgsql database name
+		User                   string `json:"User"`                   // pgsql user
+		Password               string `json:"Password"`               // pgsql pwd
+		SSLMode                string `json:"SSLMode"`                // disable, require, verify-ca or verify-full
+		SSLRootCert            string `json:"SSLRootCert"`            // CA certificate to verify the server with
+		SSLCert                string `json:"SSLCert"`                // client certificate
+		SSLKey                 string `json:"SSLKey"`                 // client certificate key
+		ApplicationName        string `json:"ApplicationName"`        // shown in pg_stat_activity
+		ConnectTimeoutSeconds  int    `json:"ConnectTimeoutSeconds"`  // 0 waits forever
+		StatementTimeoutMs     int    `json:"StatementTimeoutMs"`     // 0 disables
+		MaxOpenConns           int    `json:"MaxOpenConns"`           // 0 is unlimited
+		MaxIdleConns           int    `json:"MaxIdleConns"`           // idle connections kept in the pool
+		ConnMaxLifetimeMinutes int    `json:"ConnMaxLifetimeMinutes"` // 0 reuses connections forever
+		StartupMaxWaitSeconds  int    `json:"StartupMaxWaitSeconds"`  // keep retrying the first connection this long
+		HealthCheckSeconds     int    `json:"HealthCheckSeconds"`     // interval of background DB checks
+		SlowQueryMs            int    `json:"SlowQueryMs"`            // log queries slower than this with their arguments, 0 disables
 	} `json:"SQL"`
+	OIDC struct {
+		Issuer         string            `json:"Issuer"`         // identity provider URL, empty disables SSO
+		ClientID       string            `json:"ClientID"`       // client registered with the provider
+		ClientSecret   string            `json:"ClientSecret"`   // client secret
+		RedirectURL    string            `json:"RedirectURL"`    // public URL of /auth/oidc/callback
+		AllowedDomains []string          `json:"AllowedDomains"` // email domains allowed to log in, empty allows any
+		RoleClaim      string            `json:"RoleClaim"`      // ID token claim holding groups or roles
+		RoleMapping    map[string]string `json:"RoleMapping"`    // claim value to app role
+		DefaultRole    string            `json:"DefaultRole"`    // role given to new users without a mapped claim
+	} `json:"OIDC"`
+	AccessLog struct {
+		Format       string   `json:"Format"`       // common, combined, json or off
+		Path         string   `json:"Path"`         // file common and combined lines are written to as is, empty logs them through the log sinks
+		Level        int      `json:"Level"`        // level access lines are logged at, -1 (trace) to 2 (warn)
+		Extended     bool     `json:"Extended"`     // append request ID and latency to common and combined lines
+		SkipPaths    []string `json:"SkipPaths"`    // path prefixes never logged, e.g. /assets/
+		SampleRoutes []string `json:"SampleRoutes"` // routes logged for a SampleRatio share of requests, failures always are
+		SampleRatio  float64  `json:"SampleRatio"`  // 0 to 1
+	} `json:"AccessLog"`
+	Tracing struct {
+		Exporter    string  `json:"Exporter"`    // otlp, stdout or empty to disable tracing
+		Endpoint    string  `json:"Endpoint"`    // OTLP/HTTP collector host:port
+		Insecure    bool    `json:"Insecure"`    // send to the collector over plain HTTP
+		ServiceName string  `json:"ServiceName"` // service.name of every span
+		SampleRatio float64 `json:"SampleRatio"` // share of new traces recorded, 0 to 1
+	} `json:"Tracing"`
 }
 
-func LoadConfigFile(file string) Params {
+// ConfigErrors collects every problem found while loading or validating
+// Params so they can be reported together
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+// DefaultParams returns the built in configuration every other layer overrides
+func DefaultParams() Params {
 	var c = Params{}
 
-	if _, err := os.Stat(file); err != nil {
-		log.Panic(err.Error())
+	c.LogFile = "error.log"
+	c.LogFormat = "text"
+	c.LogLevel = 1
+	c.ListenIP = "127.0.0.1"
+	c.ListenPort = "3000"
+	c.EpochWindow = 30
+	c.SessionHours = 1
+	c.SessionMaintenance = 1
+	c.SlackAlertInterval = 300
+	c.Redis.Host = "127.0.0.1"
+	c.Redis.Port = "6379"
+	c.SQL.Host = "127.0.0.1"
+	c.SQL.Port = "5432"
+	c.SQL.DBname = "contacts"
+	c.SQL.SSLMode = "disable"
+	c.SQL.ApplicationName = "contactmanager"
+	c.SQL.ConnectTimeoutSeconds = 10
+	c.SQL.MaxOpenConns = 20
+	c.SQL.MaxIdleConns = 5
+	c.SQL.ConnMaxLifetimeMinutes = 30
+	c.SQL.StartupMaxWaitSeconds = 60
+	c.SQL.HealthCheckSeconds = 10
+	c.SQL.SlowQueryMs = 500
+	c.OIDC.DefaultRole = RoleViewer
+	c.AccessLog.Format = "combined"
+	c.AccessLog.Level = 1
+	c.AccessLog.Extended = true
+	c.AccessLog.SkipPaths = []string{"/assets/", "/healthz", "/readyz", "/metrics"}
+	c.AccessLog.SampleRatio = 0.1
+	c.Tracing.Endpoint = "localhost:4318"
+	c.Tracing.ServiceName = "contactmanager"
+	c.Tracing.SampleRatio = 1
+
+	return c
+}
+
+// Sinks returns LogSinks with LogLevel and LogFormat filled in where a sink
+// sets none, or when none are set the single file sink LogFile describes
+func (c Params) Sinks() []LogSink {
+	level := c.LogLevel
+	if len(c.LogSinks) == 0 {
+		return []LogSink{{
+			Type:   "file",
+			Path:   c.LogFile,
+			Format: c.LogFormat,
+			Level:  &level,
+		}}
 	}
 
-	// var config Params
-	configFile, err := os.Open(file)
-	defer configFile.Close()
+	sinks := make([]LogSink, len(c.LogSinks))
+	for i, sink := range c.LogSinks {
+		if sink.Level == nil {
+			sink.Level = &level
+		}
+		if sink.Format == "" {
+			sink.Format = c.LogFormat
+		}
+		sinks[i] = sink
+	}
+	return sinks
+}
+
+// LoadConfig builds Params from the built in defaults, then file (skipped when
+// empty), then CONTACTMANAGER_* environment variables, then args as command
+// line flags, resolves secret references and validates the result
+func LoadConfig(file string, args []string) (Params, error) {
+	c := DefaultParams()
 
+	if file != "" {
+		if err := LoadConfigFile(file, &c, strictFromArgs(args)); err != nil {
+			return c, err
+		}
+	}
+
+	var errs ConfigErrors
+	errs = append(errs, applyEnv(&c, os.LookupEnv)...)
+	errs = append(errs, applyFlags(&c, args)...)
+	errs = append(errs, resolveSecrets(&c)...)
+
+	// report the invalid values along with the unparsable ones
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err.(ConfigErrors)...)
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+// LoadConfigFile decodes file over the values already in c. The format
+// follows the extension: .json, .yaml/.yml or .toml. In strict mode keys that
+// match no setting are an error instead of being ignored.
+func LoadConfigFile(file string, c *Params, strict bool) error {
+	raw, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Panic(err.Error())
+		return err
 	}
 
-	jsonParser := json.NewDecoder(configFile)
-	if err = jsonParser.Decode(&c); err != nil {
-		log.Panic(err.Error())
+	// every format is read into a generic document and converted to JSON so
+	// it goes through the same json tags and unknown key check
+	var doc interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		jsonDoc := json.NewDecoder(bytes.NewReader(raw))
+		jsonDoc.UseNumber()
+		err = jsonDoc.Decode(&doc)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &doc)
+	case ".toml":
+		err = toml.Unmarshal(raw, &doc)
+	default:
+		return fmt.Errorf("%s: unknown config format, use .json, .yaml, .yml or .toml", file)
+	}
+	if err == nil {
+		raw, err = json.Marshal(quoteScalars(doc, reflect.TypeOf(*c)))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", file, err.Error())
 	}
 
-	return c
+	jsonParser := json.NewDecoder(bytes.NewReader(raw))
+	if strict {
+		jsonParser.DisallowUnknownFields()
+	}
+	if err = jsonParser.Decode(c); err != nil {
+		return fmt.Errorf("%s: %s", file, err.Error())
+	}
+
+	return nil
+}
+
+// quoteScalars turns numbers and booleans in doc into strings where the
+// matching field of t is a string, so ListenPort: 4000 means "4000" as it
+// would in an environment variable or flag
+func quoteScalars(doc interface{}, t reflect.Type) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			switch t.Kind() {
+			case reflect.Struct:
+				// encoding/json matches keys case insensitively too
+				for i := 0; i < t.NumField(); i++ {
+					if strings.EqualFold(strings.Split(t.Field(i).Tag.Get("json"), ",")[0], key) {
+						v[key] = quoteScalars(val, t.Field(i).Type)
+						break
+					}
+				}
+			case reflect.Map:
+				v[key] = quoteScalars(val, t.Elem())
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice {
+			for i := range v {
+				v[i] = quoteScalars(v[i], t.Elem())
+			}
+		}
+	case json.Number, int, int64, uint64, float64, bool:
+		if t.Kind() == reflect.String {
+			return fmt.Sprint(v)
+		}
+	}
+	return doc
+}
+
+// strictFromArgs reads -strict from the command line before the flags are
+// applied, the file has to be decoded first
+func strictFromArgs(args []string) bool {
+	scratch := DefaultParams()
+	fs := ParamsFlagSet(&scratch)
+	fs.SetOutput(ioutil.Discard)
+	fs.Parse(args)
+	strict, _ := strconv.ParseBool(fs.Lookup("strict").Value.String())
+	return strict
+}
+
+// walkParams calls fn for every leaf field of Params with its json path
+func walkParams(v reflect.Value, path []string, fn func(path []string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		fieldPath := append(append([]string{}, path...), name)
+
+		if v.Field(i).Kind() == reflect.Struct {
+			walkParams(v.Field(i), fieldPath, fn)
+			continue
+		}
+		fn(fieldPath, v.Field(i))
+	}
+}
+
+// setField parses raw into field. Lists are comma separated, maps are
+// comma separated key=value pairs.
+func setField(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		// lists of settings blocks, such as LogSinks, are given as JSON
+		if field.Type().Elem().Kind() != reflect.String {
+			return json.Unmarshal([]byte(raw), field.Addr().Interface())
+		}
+		list := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m := map[string]string{}
+		for _, pair := range strings.Split(raw, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("expected key=value, got %q", pair)
+			}
+			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		field.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// applyEnv overrides c from CONTACTMANAGER_<PATH> variables found by lookup
+func applyEnv(c *Params, lookup func(string) (string, bool)) ConfigErrors {
+	var errs ConfigErrors
+
+	walkParams(reflect.ValueOf(c).Elem(), nil, func(path []string, field reflect.Value) {
+		name := EnvPrefix + strings.ToUpper(strings.Join(path, "_"))
+		if raw, ok := lookup(name); ok {
+			if err := setField(field, raw); err != nil {
+				errs = append(errs, name+": "+err.Error())
+			}
+		}
+	})
+
+	return errs
+}
+
+// paramFlag lets the flag package set a Params field
+type paramFlag struct {
+	field reflect.Value
+}
+
+func (f paramFlag) String() string {
+	if !f.field.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(f.field.Interface())
+}
+
+func (f paramFlag) Set(raw string) error {
+	return setField(f.field, raw)
+}
+
+// ParamsFlagSet returns a flag set with one flag per Params field, named by its
+// dotted json path (e.g. -SQL.Host), writing straight into c, plus -config
+// naming the configuration file and -strict
+func ParamsFlagSet(c *Params) *flag.FlagSet {
+	fs := flag.NewFlagSet("contactmanager", flag.ContinueOnError)
+	fs.String("config", "config.json", "configuration file, .json, .yaml, .yml or .toml")
+	fs.Bool("strict", true, "reject config file keys that match no setting")
+
+	walkParams(reflect.ValueOf(c).Elem(), nil, func(path []string, field reflect.Value) {
+		fs.Var(paramFlag{field}, strings.Join(path, "."), "override "+strings.Join(path, "."))
+	})
+
+	return fs
+}
+
+// applyFlags overrides c from command line args
+func applyFlags(c *Params, args []string) ConfigErrors {
+	fs := ParamsFlagSet(c)
+	if err := fs.Parse(args); err != nil {
+		return ConfigErrors{err.Error()}
+	}
+	return nil
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+// Validate checks every setting and reports all problems at once
+func (c Params) Validate() error {
+	var errs ConfigErrors
+
+	if c.LogFile == "" && len(c.LogSinks) == 0 {
+		errs = append(errs, "LogFile is empty")
+	}
+	for i, sink := range c.LogSinks {
+		name := fmt.Sprintf("LogSinks[%d]", i)
+		switch sink.Type {
+		case "file":
+			if sink.Path == "" {
+				errs = append(errs, name+".Path is empty")
+			}
+			if sink.MaxSizeMB < 0 || sink.MaxAgeDays < 0 || sink.MaxBackups < 0 {
+				errs = append(errs, name+" rotation settings must not be negative")
+			}
+		case "stderr":
+		case "syslog":
+			switch sink.Network {
+			case "":
+			case "unix", "unixgram", "udp", "tcp":
+				if sink.Address == "" {
+					errs = append(errs, name+".Address is required with a Network")
+				}
+			default:
+				errs = append(errs, fmt.Sprintf("%s.Network %q must be unix, unixgram, udp or tcp", name, sink.Network))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("%s.Type %q must be file, stderr or syslog", name, sink.Type))
+		}
+		if sink.Format != "" && sink.Format != "text" && sink.Format != "json" {
+			errs = append(errs, fmt.Sprintf("%s.Format %q must be text or json", name, sink.Format))
+		}
+		if sink.Level != nil && (*sink.Level < -1 || *sink.Level > 5) {
+			errs = append(errs, fmt.Sprintf("%s.Level %d must be between -1 and 5", name, *sink.Level))
+		}
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Sprintf("LogFormat %q must be text or json", c.LogFormat))
+	}
+	if c.LogLevel < -1 || c.LogLevel > 5 {
+		errs = append(errs, fmt.Sprintf("LogLevel %d must be between -1 and 5", c.LogLevel))
+	}
+	if c.ListenIP != "" && net.ParseIP(c.ListenIP) == nil {
+		errs = append(errs, fmt.Sprintf("ListenIP %q is not an IP address", c.ListenIP))
+	}
+	if !validPort(c.ListenPort) {
+		errs = append(errs, fmt.Sprintf("ListenPort %q is not a valid port", c.ListenPort))
+	}
+	if c.EpochWindow < 0 {
+		errs = append(errs, "EpochWindow must not be negative")
+	}
+	if c.SessionHours <= 0 {
+		errs = append(errs, "SessionHours must be at least 1")
+	}
+	if c.SessionMaintenance < 0 {
+		errs = append(errs, "SessionMaintenance must not be negative")
+	}
+	if c.ConfigWatchSeconds < 0 {
+		errs = append(errs, "ConfigWatchSeconds must not be negative")
+	}
+
+	if c.SQL.Host == "" {
+		errs = append(errs, "SQL.Host is empty")
+	}
+	if !validPort(c.SQL.Port) {
+		errs = append(errs, fmt.Sprintf("SQL.Port %q is not a valid port", c.SQL.Port))
+	}
+	if c.SQL.DBname == "" {
+		errs = append(errs, "SQL.DBname is empty")
+	}
+	if c.SQL.User == "" {
+		errs = append(errs, "SQL.User is empty")
+	}
+	switch c.SQL.SSLMode {
+	case "disable", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Sprintf("SQL.SSLMode %q must be disable, require, verify-ca or verify-full", c.SQL.SSLMode))
+	}
+	if (c.SQL.SSLCert == "") != (c.SQL.SSLKey == "") {
+		errs = append(errs, "SQL.SSLCert and SQL.SSLKey must be set together")
+	}
+	for name, path := range map[string]string{
+		"SQL.SSLRootCert": c.SQL.SSLRootCert,
+		"SQL.SSLCert":     c.SQL.SSLCert,
+		"SQL.SSLKey":      c.SQL.SSLKey,
+	} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, name+": "+err.Error())
+		}
+	}
+	if c.SQL.ConnectTimeoutSeconds < 0 || c.SQL.StatementTimeoutMs < 0 || c.SQL.ConnMaxLifetimeMinutes < 0 ||
+		c.SQL.StartupMaxWaitSeconds < 0 {
+		errs = append(errs, "SQL timeouts and lifetimes must not be negative")
+	}
+	if c.SQL.SlowQueryMs < 0 {
+		errs = append(errs, "SQL.SlowQueryMs must not be negative")
+	}
+	if c.SQL.HealthCheckSeconds <= 0 {
+		errs = append(errs, "SQL.HealthCheckSeconds must be at least 1")
+	}
+	if c.SQL.MaxOpenConns < 0 || c.SQL.MaxIdleConns < 0 {
+		errs = append(errs, "SQL.MaxOpenConns and SQL.MaxIdleConns must not be negative")
+	}
+	if c.SQL.MaxOpenConns > 0 && c.SQL.MaxIdleConns > c.SQL.MaxOpenConns {
+		errs = append(errs, "SQL.MaxIdleConns must not exceed SQL.MaxOpenConns")
+	}
+	if c.SlackHook != "" {
+		if u, err := url.Parse(c.SlackHook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, "SlackHook must be an http(s) URL")
+		}
+	}
+	if c.SlackAlertInterval < 0 {
+		errs = append(errs, "SlackAlertIntervalSeconds must not be negative")
+	}
+	if c.Redis.Port != "" && !validPort(c.Redis.Port) {
+		errs = append(errs, fmt.Sprintf("Redis.Port %q is not a valid port", c.Redis.Port))
+	}
+	if c.Redis.Enabled && c.Redis.Host == "" {
+		errs = append(errs, "Redis.Host is required when Redis.Enabled is set")
+	}
+
+	if c.OIDC.Issuer != "" {
+		if c.OIDC.ClientID == "" {
+			errs = append(errs, "OIDC.ClientID is required when OIDC.Issuer is set")
+		}
+		if c.OIDC.RedirectURL == "" {
+			errs = append(errs, "OIDC.RedirectURL is required when OIDC.Issuer is set")
+		}
+	}
+	if c.OIDC.DefaultRole != "" && !ValidRole(c.OIDC.DefaultRole) {
+		errs = append(errs, fmt.Sprintf("OIDC.DefaultRole %q is not a role", c.OIDC.DefaultRole))
+	}
+	for claim, role := range c.OIDC.RoleMapping {
+		if !ValidRole(role) {
+			errs = append(errs, fmt.Sprintf("OIDC.RoleMapping[%q] %q is not a role", claim, role))
+		}
+	}
+	switch c.AccessLog.Format {
+	case "common", "combined", "json", "off":
+	default:
+		errs = append(errs, fmt.Sprintf("AccessLog.Format %q must be common, combined, json or off", c.AccessLog.Format))
+	}
+	// errors and above would raise a Slack alert per request
+	if c.AccessLog.Level < -1 || c.AccessLog.Level > 2 {
+		errs = append(errs, fmt.Sprintf("AccessLog.Level %d must be between -1 and 2", c.AccessLog.Level))
+	}
+	if c.AccessLog.SampleRatio < 0 || c.AccessLog.SampleRatio > 1 {
+		errs = append(errs, "AccessLog.SampleRatio must be between 0 and 1")
+	}
+	switch c.Tracing.Exporter {
+	case "", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint == "" {
+			errs = append(errs, "Tracing.Endpoint is required for the otlp exporter")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("Tracing.Exporter %q must be otlp, stdout or empty", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, "Tracing.SampleRatio must be between 0 and 1")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigReportsEveryError(t *testing.T) {
+	t.Setenv(EnvPrefix+"SESSIONHOURS", "many")
+
+	_, err := LoadConfig("", []string{"-ListenPort=99999", "-SQL.User=test"})
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("LoadConfig returned %v, want ConfigErrors", err)
+	}
+
+	for _, want := range []string{"SESSIONHOURS", `ListenPort "99999"`} {
+		found := false
+		for _, e := range errs {
+			found = found || strings.Contains(e, want)
+		}
+		if !found {
+			t.Errorf("no error mentions %s in %q", want, errs)
+		}
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	c, err := LoadConfig("", []string{"-SQL.User=test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ListenPort != "3000" {
+		t.Errorf("ListenPort %q, want the default 3000", c.ListenPort)
+	}
+}
+
+func TestLoadConfigFileNumericStrings(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{"ListenPort": 4000, "SQL": {"Port": 5433, "User": "test"}, "Redis": {"Size": 6},
+			"OIDC": {"RoleMapping": {"staff": "editor"}}, "SessionHours": 2}`,
+		"config.yaml": "ListenPort: 4000\nSQL:\n  Port: 5433\n  User: test\nRedis:\n  Size: 6\n" +
+			"OIDC:\n  RoleMapping:\n    staff: editor\nSessionHours: 2\n",
+		"config.toml": "ListenPort = 4000\nSessionHours = 2\n[SQL]\nPort = 5433\nUser = \"test\"\n" +
+			"[Redis]\nSize = 6\n[OIDC.RoleMapping]\nstaff = \"editor\"\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c := DefaultParams()
+			if err := LoadConfigFile(file, &c, true); err != nil {
+				t.Fatal(err)
+			}
+			if c.ListenPort != "4000" || c.SQL.Port != "5433" || c.Redis.Size != "6" {
+				t.Errorf("ListenPort %q, SQL.Port %q, Redis.Size %q", c.ListenPort, c.SQL.Port, c.Redis.Size)
+			}
+			if c.SessionHours != 2 || c.SQL.User != "test" || c.OIDC.RoleMapping["staff"] != RoleEditor {
+				t.Errorf("SessionHours %d, SQL.User %q, RoleMapping %v", c.SessionHours, c.SQL.User, c.OIDC.RoleMapping)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileStrictStillRejectsUnknownKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("ListenPort: 4000\nListenPrt: 4001\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := DefaultParams()
+	if err := LoadConfigFile(file, &c, true); err == nil {
+		t.Error("unknown key ListenPrt accepted in strict mode")
+	}
+}
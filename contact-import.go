@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// importCSV inserts the contacts of a CSV file with a contactColumns header
+// into book, all or nothing. A malformed file is reported as ErrBadRequest
+// naming the line. c may be nil outside a request.
+func (ac *appContext) importCSV(c *gin.Context, in io.Reader, book string) (int, error) {
+	r := csv.NewReader(in)
+	header, err := r.Read()
+	if err != nil {
+		return 0, ErrBadRequest.Because("reading the CSV header: " + err.Error())
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	ctx := ac.QueryCtx(c, "contacts.import")
+	tx, err := ac.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `insert into contacts (first_name, last_name, phone, office_phone, city, state, zip, address_book_id)
+values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	count := 0
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, ErrBadRequest.Because(err.Error())
+		}
+
+		field := func(name string) string {
+			if i, ok := index[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		var phone, officePhone int64
+		if v := field("phone"); v != "" {
+			if phone, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return 0, ErrBadRequest.Because(fmt.Sprintf("line %d: phone %q is not a number", line, v))
+			}
+		}
+		if v := field("office_phone"); v != "" {
+			if officePhone, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return 0, ErrBadRequest.Because(fmt.Sprintf("line %d: office_phone %q is not a number", line, v))
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, query, field("first_name"), field("last_name"), phone, officePhone,
+			field("city"), field("state"), field("zip"), book)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		count++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// importContacts imports the CSV uploaded as `file` into the current book,
+// for users and tokens holding PermContactImport
+func (ac *appContext) importContacts(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		ac.JSONError(http.StatusBadRequest, "a CSV file is required", c)
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	defer f.Close()
+
+	book := CurrentBook(c)
+	count, err := ac.importCSV(c, f, book.ID)
+	var appErr *AppError
+	if errors.As(err, &appErr) && appErr.Err != nil {
+		ac.JSONError(appErr.Status, appErr.Err.Error(), c)
+		return
+	}
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+
+	ac.Log.WithContext(c).Msg(1, fmt.Sprintf("Imported [ %d ] contacts into [ %s ] by [ %s ]", count, book.ID, CurrentUser(c).Username))
+	c.JSON(http.StatusOK, gin.H{"data": count})
+}
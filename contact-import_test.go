@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const importCSVFile = "first_name,last_name,phone\nAda,Lovelace,5550001\nAlan,Turing,5550002\n"
+
+// importDB answers the statements of an import into book b1, roles maps
+// usernames to their role for the command line check
+func importDB(roles map[string]string) *fakeDB {
+	return &fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+		switch {
+		case strings.HasPrefix(query, "select b.id, b.name"):
+			return fakeRows([]string{"id", "name", "personal", "permission"}, []driver.Value{"b1", "Team", false, BookWrite})
+		case strings.HasPrefix(query, "select role from users"):
+			if role, ok := roles[args[0].(string)]; ok {
+				return fakeRows([]string{"role"}, []driver.Value{role})
+			}
+			return fakeRows([]string{"role"})
+		case strings.HasPrefix(query, "insert into contacts"):
+			return fakeResult{affected: 1}
+		}
+		return fakeResult{err: errors.New("unexpected query: " + query)}
+	}}
+}
+
+func TestImportContacts(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   string
+		scopes []string
+		file   string
+		want   int
+	}{
+		{name: "admin imports", role: RoleAdmin, file: importCSVFile, want: http.StatusOK},
+		{name: "editor may not import", role: RoleEditor, file: importCSVFile, want: http.StatusForbidden},
+		{name: "import token imports", role: RoleAdmin, scopes: []string{ScopeImport}, file: importCSVFile, want: http.StatusOK},
+		{name: "write token may not import", role: RoleAdmin, scopes: []string{ScopeContactsWrite}, file: importCSVFile, want: http.StatusForbidden},
+		{name: "bad phone is refused", role: RoleAdmin, file: "first_name,phone\nAda,call me\n", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac, _ := newTestContext()
+			db := importDB(nil)
+			ac.DB = db.open()
+
+			r := gin.New()
+			r.POST("/importContacts", func(c *gin.Context) {
+				c.Set("user", &User{ID: "u1", Username: "alice", Role: tt.role})
+				if tt.scopes != nil {
+					c.Set("tokenScopes", tt.scopes)
+				}
+			}, ac.RequirePermission(PermContactImport), ac.RequireBookFor(BookWrite, PermContactImport), ac.importContacts)
+
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			fw, _ := mw.CreateFormFile("file", "contacts.csv")
+			fw.Write([]byte(tt.file))
+			mw.Close()
+			req := httptest.NewRequest("POST", "/importContacts", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			req.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want == http.StatusOK && !strings.Contains(w.Body.String(), `"data":2`) {
+				t.Errorf("answer %s, want 2 contacts", w.Body.String())
+			}
+			if tt.want == http.StatusBadRequest && !strings.Contains(w.Body.String(), "line 2") {
+				t.Errorf("answer %s does not name the line", w.Body.String())
+			}
+			if tt.want == http.StatusForbidden && db.ran("insert into contacts") {
+				t.Error("a refused import inserted contacts")
+			}
+		})
+	}
+}
+
+func TestCmdImport(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "contacts.csv")
+	if err := os.WriteFile(file, []byte(importCSVFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-user", "root", file}, ""},
+		{[]string{"-user", "ed", file}, "may not import"},
+		{[]string{"-user", "nobody", file}, "no enabled user"},
+		{[]string{file}, "usage"},
+	}
+	for _, tt := range tests {
+		ac, _ := newTestContext()
+		db := importDB(map[string]string{"root": RoleAdmin, "ed": RoleEditor})
+		ac.DB = db.open()
+
+		var err error
+		out := captureStdout(t, func() { err = cmdImport(ac, tt.args) })
+		if tt.err == "" {
+			if err != nil || !strings.Contains(out, "imported 2 contacts") {
+				t.Errorf("import %v: %v, printed %q", tt.args, err, out)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("import %v: %v, want %q", tt.args, err, tt.err)
+		}
+		if db.ran("insert into contacts") {
+			t.Errorf("import %v inserted contacts", tt.args)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dbRetryInitial = 500 * time.Millisecond
+	dbRetryMax     = 15 * time.Second
+)
+
+// PostgresDSN builds the lib/pq connection URL for the SQL settings, escaping
+// every part so passwords may contain '@', ':' or '/'
+func PostgresDSN(conf Params) *url.URL {
+	q := url.Values{}
+	q.Set("sslmode", conf.SQL.SSLMode)
+	if conf.SQL.SSLRootCert != "" {
+		q.Set("sslrootcert", conf.SQL.SSLRootCert)
+	}
+	if conf.SQL.SSLCert != "" {
+		q.Set("sslcert", conf.SQL.SSLCert)
+		q.Set("sslkey", conf.SQL.SSLKey)
+	}
+	if conf.SQL.ApplicationName != "" {
+		q.Set("application_name", conf.SQL.ApplicationName)
+	}
+	if conf.SQL.ConnectTimeoutSeconds > 0 {
+		q.Set("connect_timeout", strconv.Itoa(conf.SQL.ConnectTimeoutSeconds))
+	}
+	if conf.SQL.StatementTimeoutMs > 0 {
+		// unknown keys are sent to the server as run-time parameters
+		q.Set("statement_timeout", strconv.Itoa(conf.SQL.StatementTimeoutMs))
+	}
+
+	return &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(conf.SQL.User, conf.SQL.Password),
+		Host:     net.JoinHostPort(conf.SQL.Host, conf.SQL.Port),
+		Path:     "/" + conf.SQL.DBname,
+		RawQuery: q.Encode(),
+	}
+}
+
+// InitDB opens the pool and retries the first connection with exponential
+// backoff for up to SQL.StartupMaxWaitSeconds. If the database is still down
+// the server starts in maintenance mode and MonitorDB picks it up later.
+func InitDB(c *appContext) {
+	conf := c.Config()
+
+	dsn := PostgresDSN(conf)
+
+	c.Log.Msg(0, "PG Connection String: "+dsn.Redacted())
+
+	connector, err := pq.NewConnector(dsn.String())
+	if err != nil {
+		c.Log.Msg(5, err.Error())
+	}
+	c.DB = sql.OpenDB(instrumentedConnector{Connector: connector, ac: c})
+	registerDBStats(c.DB, conf.SQL.DBname)
+
+	c.DB.SetMaxOpenConns(conf.SQL.MaxOpenConns)
+	c.DB.SetMaxIdleConns(conf.SQL.MaxIdleConns)
+	c.DB.SetConnMaxLifetime(time.Duration(conf.SQL.ConnMaxLifetimeMinutes) * time.Minute)
+
+	deadline := time.Now().Add(time.Duration(conf.SQL.StartupMaxWaitSeconds) * time.Second)
+	wait := dbRetryInitial
+	for {
+		if err = c.pingDB(); err == nil {
+			break
+		}
+		if time.Now().Add(wait).After(deadline) {
+			c.Log.Msg(3, "DB Ping failed, starting in maintenance mode: "+err.Error())
+			c.setDBHealthy(false)
+			return
+		}
+		c.Log.Msg(2, "DB Ping failed, retrying in "+wait.String()+": "+err.Error())
+		time.Sleep(wait)
+		if wait *= 2; wait > dbRetryMax {
+			wait = dbRetryMax
+		}
+	}
+
+	c.setDBHealthy(true)
+	c.Log.Msg(0, "Successfully connected to database [ "+
+		conf.SQL.DBname+" ]")
+}
+
+func (ac *appContext) pingDB() error {
+	timeout := time.Duration(ac.Config().SQL.HealthCheckSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return ac.DB.PingContext(ctx)
+}
+
+func (ac *appContext) DBHealthy() bool {
+	return atomic.LoadInt32(&ac.dbHealthy) == 1
+}
+
+func (ac *appContext) setDBHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	if old := atomic.SwapInt32(&ac.dbHealthy, v); old != v {
+		if healthy {
+			ac.Log.Msg(1, "Database reachable, leaving maintenance mode")
+		} else {
+			ac.Log.Msg(3, "Database unreachable, entering maintenance mode")
+		}
+	}
+}
+
+// CheckDB pings the database and updates the health flag
+func (ac *appContext) CheckDB() bool {
+	err := ac.pingDB()
+	if err != nil {
+		ac.Log.Msg(0, "DB health check failed: "+err.Error())
+	}
+	ac.setDBHealthy(err == nil)
+	return err == nil
+}
+
+// CheckDBSoon runs CheckDB in the background unless one started here is still
+// running, a burst of failed queries costs a single ping
+func (ac *appContext) CheckDBSoon() {
+	if !atomic.CompareAndSwapInt32(&ac.dbChecking, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&ac.dbChecking, 0)
+		ac.CheckDB()
+	}()
+}
+
+// MonitorDB re-checks the database every SQL.HealthCheckSeconds so the app
+// flips between healthy and maintenance mode on its own
+func (ac *appContext) MonitorDB() {
+	go func() {
+		for {
+			time.Sleep(time.Duration(ac.Config().SQL.HealthCheckSeconds) * time.Second)
+			ac.CheckDB()
+		}
+	}()
+}
+
+// RequireDB answers with 503 while the database is unreachable, pages get the
+// maintenance template and API clients a JSON error
+func (ac *appContext) RequireDB(c *gin.Context) {
+	if ac.DBHealthy() {
+		c.Next()
+		return
+	}
+
+	if c.Request.Method == http.MethodGet && !wantsJSON(c) {
+		c.HTML(http.StatusServiceUnavailable, "errors/maintenance", gin.H{"requestID": RequestID(c)})
+	} else {
+		ac.JSONError(http.StatusServiceUnavailable, "database unavailable", c)
+	}
+	c.Abort()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFailedQueriesCoalesceDBChecks(t *testing.T) {
+	ac, _ := newTestContext()
+	conf := ac.Config()
+	conf.SQL.HealthCheckSeconds = 5
+	ac.config.Store(conf)
+
+	var pings int32
+	release := make(chan struct{})
+	done := make(chan struct{}, 1)
+	ac.DB = (&fakeDB{ping: func(ctx context.Context) error {
+		atomic.AddInt32(&pings, 1)
+		<-release
+		done <- struct{}{}
+		return errors.New("connection refused")
+	}}).open()
+	atomic.StoreInt32(&ac.dbHealthy, 1)
+
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		ac.DBErrorCheck(errors.New("connection reset"), "select 1", c)
+	})
+	for i := 0; i < 50; i++ {
+		if w := jsonRequest(r, "GET", "/", ""); w.Code != http.StatusInternalServerError {
+			t.Fatalf("status %d, want 500", w.Code)
+		}
+	}
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the DB check never ran")
+	}
+	for atomic.LoadInt32(&ac.dbChecking) == 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&pings); n != 1 {
+		t.Errorf("%d pings for one burst of failed queries, want 1", n)
+	}
+	if ac.DBHealthy() {
+		t.Error("a failed ping left the database healthy")
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	tests := []struct {
+		user     string
+		password string
+	}{
+		{"app", "plain"},
+		{"app@corp", "p@ss:w/rd?x=1"},
+		{"first last", "with space & #hash"},
+		{"a:b/c", "%41 100%"},
+	}
+	for _, tt := range tests {
+		conf := DefaultParams()
+		conf.SQL.User = tt.user
+		conf.SQL.Password = tt.password
+		conf.SQL.Host = "db.internal"
+		conf.SQL.DBname = "my contacts"
+		conf.SQL.SSLMode = "verify-full"
+		conf.SQL.SSLRootCert = "/etc/ssl/root ca.pem"
+		conf.SQL.SSLCert = "/etc/ssl/client.pem"
+		conf.SQL.SSLKey = "/etc/ssl/client.key"
+
+		dsn := PostgresDSN(conf)
+		u, err := url.Parse(dsn.String())
+		if err != nil {
+			t.Errorf("%q: %v", dsn.String(), err)
+			continue
+		}
+		password, _ := u.User.Password()
+		if u.User.Username() != tt.user || password != tt.password {
+			t.Errorf("%q parses to user %q password %q", dsn.String(), u.User.Username(), password)
+		}
+		if u.Hostname() != "db.internal" || u.Port() != "5432" || u.Path != "/my contacts" {
+			t.Errorf("%q parses to host %q port %q path %q", dsn.String(), u.Hostname(), u.Port(), u.Path)
+		}
+		q := u.Query()
+		if q.Get("sslmode") != "verify-full" || q.Get("sslrootcert") != "/etc/ssl/root ca.pem" ||
+			q.Get("sslcert") != "/etc/ssl/client.pem" || q.Get("sslkey") != "/etc/ssl/client.key" {
+			t.Errorf("%q has ssl params %v", dsn.String(), q)
+		}
+
+		if redacted := dsn.Redacted(); strings.Contains(redacted, url.QueryEscape(tt.password)) ||
+			strings.Contains(redacted, tt.password) || !strings.Contains(redacted, "xxxxx") {
+			t.Errorf("Redacted() shows the password: %s", redacted)
+		}
+	}
+}
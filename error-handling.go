@@ -5,126 +5,257 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
 )
 
 type ErrorHandler struct {
 	Log      *logrus.Logger
 	LogLevel int
+	Redactor *Redactor
+	Slack    *SlackNotifier
+	sinks    []*logSink
+	access   *logSink // AccessLog.Path, nil when access lines go to the sinks
 }
 
 func (e *ErrorHandler) SetLogLevel(level int) {
-
-	switch level {
-	case -1:
-		e.Log.SetLevel(logrus.TraceLevel)
-	case 0:
-		e.Log.SetLevel(logrus.DebugLevel)
-	case 1:
-		e.Log.SetLevel(logrus.InfoLevel)
-	case 2:
-		e.Log.SetLevel(logrus.WarnLevel)
-	case 3:
-		e.Log.SetLevel(logrus.ErrorLevel)
-	case 4:
-		e.Log.SetLevel(logrus.FatalLevel)
-	case 5:
-		e.Log.SetLevel(logrus.PanicLevel)
-	}
+	e.Log.SetLevel(logrusLevel(level))
 }
 
+// InitLog opens every configured sink. A sink that cannot be opened is
+// replaced with stderr so no message is lost.
 func (e *ErrorHandler) InitLog(c *Params) {
 	e.Log = logrus.New()
+	// entries are written by the sink hooks only
+	e.Log.Out = ioutil.Discard
 
-	logFile, err := os.OpenFile(c.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
-	if err != nil {
-		fmt.Println("Failed to log to file, using default stderr")
+	e.Redactor = &Redactor{}
+	e.Log.AddHook(e.Redactor)
+
+	for _, conf := range c.Sinks() {
+		sink, err := openLogSink(conf)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to open "+conf.Type+" log "+conf.Path+", using stderr: "+err.Error())
+			conf.Type = "stderr"
+			sink, _ = openLogSink(conf)
+		}
+		e.sinks = append(e.sinks, sink)
+		e.Log.AddHook(sink)
 	}
 
-	e.Log.Out = logFile
+	if path := c.AccessLog.Path; path != "" {
+		access, err := openAccessLog(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to open access log "+path+", logging access lines with the rest: "+err.Error())
+		}
+		e.access = access
+	}
 
-	if c.LogFormat == "json" {
-		e.Log.SetFormatter(&logrus.JSONFormatter{})
-	} else {
-		// The TextFormatter is default, you don't actually have to do this.
-		e.Log.SetFormatter(&logrus.TextFormatter{
-			FullTimestamp: true,
-		})
+	e.Slack = NewSlackNotifier()
+	e.Slack.OnError = func(err error) {
+		e.Msg(2, err.Error())
 	}
 
-	e.SetLogLevel(c.LogLevel)
+	e.ApplyConfig(c)
+}
+
+// ApplyConfig sets the sink levels and formats, redacted secrets and Slack
+// settings from c, safe to call on a live logger
+func (e *ErrorHandler) ApplyConfig(c *Params) {
+	e.Redactor.SetSecrets(c.Secrets())
+	e.Slack.Configure(c.SlackHook, c.SlackChannel, time.Duration(c.SlackAlertInterval)*time.Second)
+
+	// the logger drops entries below its own level before any sink sees them,
+	// so it runs at the most verbose sink's level
+	lowest := 5
+	for i, conf := range c.Sinks() {
+		if i < len(e.sinks) {
+			e.sinks[i].configure(conf)
+		}
+		if *conf.Level < lowest {
+			lowest = *conf.Level
+		}
+	}
+	e.SetLogLevel(lowest)
+}
+
+// LogEntry is the ErrorHandler bound to a set of structured fields, such as
+// the per request logger RequestLogger attaches to the gin context
+type LogEntry struct {
+	e     *ErrorHandler
+	Entry *logrus.Entry
 }
 
 func (e *ErrorHandler) Msg(levelNum int, message string) {
+	e.msg(logrus.NewEntry(e.Log), levelNum, message)
+}
+
+// Access writes an access line to the AccessLog.Path file as is, or logs it
+// at levelNum through the sinks when there is no such file
+func (e *ErrorHandler) Access(levelNum int, line string) {
+	if e.access == nil {
+		e.Msg(levelNum, line)
+		return
+	}
+	if err := e.access.Fire(&logrus.Entry{Message: e.Redactor.Redact(line)}); err != nil {
+		e.Msg(2, "Writing access log failed: "+err.Error())
+	}
+}
+
+func (e *ErrorHandler) msg(entry *logrus.Entry, levelNum int, message string) {
 	if levelNum >= e.LogLevel {
+		e.SlackAlert(entry, levelNum, message)
+
 		switch levelNum {
 		case -1:
-			e.Log.Trace(message)
+			entry.Trace(message)
 		case 0:
-			e.Log.Debug(message)
+			entry.Debug(message)
 		case 1:
-			e.Log.Info(message)
+			entry.Info(message)
 		case 2:
-			e.Log.Warn(message)
+			entry.Warn(message)
 		case 3:
-			e.Log.Error(message)
+			entry.Error(message)
 		case 4:
-			e.Log.Fatal(message)
+			entry.Fatal(message)
 		case 5:
-			e.Log.Panic(message)
+			entry.Panic(message)
 		}
 	}
 }
 
-func (e *ErrorHandler) SlackAlert() {
-	// TODO fill in with slack webhook to alert error
+// WithFields returns a logger that adds fields to every message
+func (e *ErrorHandler) WithFields(fields logrus.Fields) *LogEntry {
+	return &LogEntry{e: e, Entry: e.Log.WithFields(fields)}
 }
 
-func (ac *appContext) DBErrorCheck(err error, query string, c *gin.Context) bool {
-	ac.Log.Msg(0, "Query: "+query)
+// WithContext returns the request logger attached to c, or a plain one when
+// c carries none
+func (e *ErrorHandler) WithContext(c *gin.Context) *LogEntry {
+	if c != nil {
+		if l, ok := c.Get(logContextKey); ok {
+			return l.(*LogEntry)
+		}
+	}
+	return &LogEntry{e: e, Entry: logrus.NewEntry(e.Log)}
+}
 
-	ac.Log.Msg(0, "-----------------------------")
+func (l *LogEntry) WithFields(fields logrus.Fields) *LogEntry {
+	return &LogEntry{e: l.e, Entry: l.Entry.WithFields(fields)}
+}
+
+func (l *LogEntry) Msg(levelNum int, message string) {
+	l.e.msg(l.Entry, levelNum, message)
+}
+
+// SlackAlert sends error level and worse messages to SlackHook. Fatal and
+// panic ones are posted before returning since the process is about to stop.
+func (e *ErrorHandler) SlackAlert(entry *logrus.Entry, levelNum int, message string) {
+	if e.Slack == nil || levelNum < 3 {
+		return
+	}
+
+	alert, ok := e.newSlackAlert(entry, levelNum, message)
+	if !ok {
+		return
+	}
+	if levelNum >= 4 {
+		e.Slack.AlertNow(alert)
+	} else {
+		e.Slack.Alert(alert)
+	}
+}
+
+// DBErrorCheck aborts the request with a 500 when err is a query failure. The
+// instrumented driver already logs every query with its timing, so only the
+// failure itself is logged here.
+func (ac *appContext) DBErrorCheck(err error, query string, c *gin.Context) bool {
 	switch err {
 	case nil:
-		ac.Log.Msg(0, "Query good")
 	case sql.ErrNoRows:
-		ac.Log.Msg(1, "Now rows returned")
+		ac.Log.WithContext(c).WithFields(logrus.Fields{"query": queryName(query)}).Msg(1, "No rows returned")
 	default:
-		ac.Log.Msg(0, "----------ERROR--------------")
-		ac.Log.Msg(3, "DB Query failed: "+err.Error())
+		ac.Log.WithContext(c).WithFields(logrus.Fields{
+			"query": queryName(query),
+			"error": err.Error(),
+		}).Msg(3, "DB Query failed")
+		// a dead connection should flip the app into maintenance mode quickly
+		ac.CheckDBSoon()
 		ac.AbortMsg(500, err, c)
 		return false
 	}
-	ac.Log.Msg(0, "-----------------------------")
 	return true
 }
 
+// AbortMsg aborts the request with status. err may be a catalog AppError,
+// anything else is reported as status' catalog entry.
 func (ac *appContext) AbortMsg(code int, err error, c *gin.Context) bool {
-	var errFile string
-	var errMsg string
+	return ac.AbortError(asAppError(code, err), c)
+}
 
-	if ac.ConfigData.Debug > 0 {
-		errMsg = err.Error()
-	}
+// AbortError aborts the request with err, as JSON for XHR and API requests
+// and as the status' error page otherwise. Errors outside the catalog are
+// internal errors.
+func (ac *appContext) AbortError(err error, c *gin.Context) bool {
+	appErr := asAppError(http.StatusInternalServerError, err)
 
-	switch code {
-	case http.StatusNotFound:
-		errFile = "errors/404"
-	case http.StatusNotAcceptable:
-		errFile = "errors/verification"
-	case http.StatusInternalServerError:
-		errFile = "errors/500"
+	var detail string
+	if ac.Config().Debug > 0 {
+		detail = ac.Log.Redactor.Redact(err.Error())
 	}
 
-	ac.Log.Msg(3, "Aborting: "+err.Error())
-
-	c.HTML(code, errFile, gin.H{
-		"error": errMsg,
-	})
+	// only server errors are worth an alert
+	level := 2
+	if appErr.Status >= http.StatusInternalServerError {
+		level = 3
+	}
+	ac.Log.WithContext(c).WithFields(logrus.Fields{
+		"status": appErr.Status,
+		"code":   appErr.Code,
+		"error":  err.Error(),
+	}).Msg(level, "Aborting")
 
+	writeError(appErr, detail, c)
 	c.Error(err)
 	c.Abort()
 
 	return false
 }
+
+// writeError renders appErr, detail is only set in Debug mode
+func writeError(appErr *AppError, detail string, c *gin.Context) {
+	if wantsJSON(c) {
+		body := gin.H{
+			"error":      appErr.Message,
+			"code":       appErr.Code,
+			"request_id": RequestID(c),
+		}
+		if detail != "" {
+			body["detail"] = detail
+		}
+		c.JSON(appErr.Status, body)
+	} else {
+		c.HTML(appErr.Status, errorTemplate(appErr.Status), gin.H{
+			"status":    appErr.Status,
+			"code":      appErr.Code,
+			"message":   appErr.Message,
+			"error":     detail,
+			"requestID": RequestID(c),
+		})
+	}
+}
+
+// wantsJSON reports whether c came from script or an API client rather than
+// a browser navigating
+func wantsJSON(c *gin.Context) bool {
+	if c.GetHeader("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+	if _, ok := bearerToken(c); ok {
+		return true
+	}
+	return c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
+}
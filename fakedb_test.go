@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// fakeResult is the answer fakeDB gives to one statement
+type fakeResult struct {
+	cols     []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+func fakeRows(cols []string, rows ...[]driver.Value) fakeResult {
+	return fakeResult{cols: cols, rows: rows}
+}
+
+// fakeDB is a database/sql driver answering every statement through respond,
+// which sees the statement with its whitespace collapsed. Statements respond
+// does not know should return an error so a test notices them.
+type fakeDB struct {
+	mu      sync.Mutex
+	queries []string
+	respond func(query string, args []driver.Value) fakeResult
+
+	// ping answers PingContext when set
+	ping func(ctx context.Context) error
+}
+
+func (f *fakeDB) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{f})
+}
+
+func (f *fakeDB) run(query string, named []driver.NamedValue) fakeResult {
+	query = strings.Join(strings.Fields(query), " ")
+	args := make([]driver.Value, len(named))
+	for i, nv := range named {
+		args[i] = nv.Value
+	}
+
+	f.mu.Lock()
+	f.queries = append(f.queries, query)
+	f.mu.Unlock()
+	return f.respond(query, args)
+}
+
+// ran reports whether a statement containing part was run
+func (f *fakeDB) ran(part string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, q := range f.queries {
+		if strings.Contains(q, part) {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeConnector struct {
+	db *fakeDB
+}
+
+func (fc fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: fc.db}, nil
+}
+
+func (fc fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakeDriver is only used through its connector")
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fakeConn does not prepare statements")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	if c.db.ping == nil {
+		return nil
+	}
+	return c.db.ping(ctx)
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := c.db.run(query, args)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return &fakeRowSet{res: res}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := c.db.run(query, args)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+// CheckNamedValue accepts any argument, pq.Array and friends are converted
+// through their Value method
+func (c *fakeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if v, ok := nv.Value.(driver.Valuer); ok {
+		val, err := v.Value()
+		nv.Value = val
+		return err
+	}
+	return nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRowSet struct {
+	res fakeResult
+	pos int
+}
+
+func (r *fakeRowSet) Columns() []string {
+	return r.res.cols
+}
+
+func (r *fakeRowSet) Close() error {
+	return nil
+}
+
+func (r *fakeRowSet) Next(dest []driver.Value) error {
+	if r.pos >= len(r.res.rows) {
+		return io.EOF
+	}
+	copy(dest, r.res.rows[r.pos])
+	r.pos++
+	return nil
+}
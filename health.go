@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requiredTemplates must have loaded for the site to serve pages
+var requiredTemplates = []string{
+	"main/index",
+	"main/login",
+	"errors/500",
+	"errors/maintenance",
+}
+
+// healthCheck is one dependency reported by /readyz. When a required check
+// fails the instance is taken out of rotation, optional ones are only shown.
+type healthCheck struct {
+	Name     string
+	Required bool
+	Check    func() error
+}
+
+type checkResult struct {
+	Status     string `json:"status"`
+	Required   bool   `json:"required"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (ac *appContext) readinessChecks(c *gin.Context) []healthCheck {
+	checks := []healthCheck{
+		{Name: "database", Required: true, Check: func() error {
+			// CheckDB also flips maintenance mode, same as a failed query would
+			if !ac.CheckDB() {
+				return errors.New("ping failed")
+			}
+			return nil
+		}},
+		{Name: "migrations", Required: true, Check: func() error {
+			pending, err := ac.PendingMigrations(c)
+			if err != nil {
+				return err
+			}
+			if len(pending) > 0 {
+				return errors.New("pending: " + strings.Join(pending, ", "))
+			}
+			return nil
+		}},
+		{Name: "templates", Required: true, Check: ac.checkTemplates},
+	}
+
+	// once enabled Redis is required, including when the connect at start up
+	// failed and left RedisPing unset
+	if ac.Config().Redis.Enabled {
+		checks = append(checks, healthCheck{Name: "redis", Required: true, Check: func() error {
+			if ac.RedisPing == nil {
+				return errors.New("not connected")
+			}
+			var failed []string
+			for node, err := range ac.RedisPing() {
+				if err != nil {
+					failed = append(failed, node+": "+err.Error())
+				}
+			}
+			if len(failed) > 0 {
+				return errors.New(strings.Join(failed, "; "))
+			}
+			return nil
+		}})
+	}
+
+	return checks
+}
+
+func (ac *appContext) checkTemplates() error {
+	if ac.Templates == nil {
+		return errors.New("templates not loaded")
+	}
+	var missing []string
+	for _, name := range requiredTemplates {
+		if _, ok := ac.Templates.Templates[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New("missing: " + strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// healthz answers as long as the process can serve HTTP
+func (ac *appContext) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz runs every readiness check in parallel and answers 503 when a
+// required one fails
+func (ac *appContext) readyz(c *gin.Context) {
+	checks := ac.readinessChecks(c)
+	results := make(map[string]checkResult, len(checks))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check healthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := check.Check()
+
+			res := checkResult{
+				Status:     "ok",
+				Required:   check.Required,
+				DurationMs: time.Since(start).Nanoseconds() / int64(time.Millisecond),
+			}
+			if err != nil {
+				res.Status = "down"
+				res.Error = ac.Log.Redactor.Redact(err.Error())
+			}
+			mu.Lock()
+			results[check.Name] = res
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	code, status := http.StatusOK, "ok"
+	for _, res := range results {
+		if res.Required && res.Status != "ok" {
+			code, status = http.StatusServiceUnavailable, "unavailable"
+		}
+	}
+
+	c.JSON(code, gin.H{
+		"status": status,
+		"checks": results,
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"contactmanager/GinHTMLRender"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// readyContext passes every check but Redis
+func readyContext(t *testing.T) *appContext {
+	ac, _ := newTestContext()
+	ac.DB = (&fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "select name from schema_migrations") {
+			rows := fakeRows([]string{"name"})
+			for _, name := range migrations {
+				rows.rows = append(rows.rows, []driver.Value{name})
+			}
+			return rows
+		}
+		return fakeResult{err: errors.New("unexpected query: " + query)}
+	}}).open()
+
+	ac.Templates = &GinHTMLRender.Render{Templates: map[string]*template.Template{}}
+	for _, name := range requiredTemplates {
+		ac.Templates.Templates[name] = template.New(name)
+	}
+	return ac
+}
+
+func TestReadyzRedis(t *testing.T) {
+	tests := []struct {
+		enabled bool
+		ping    func() map[string]error
+		want    int
+		redis   string
+	}{
+		{false, nil, http.StatusOK, ""},
+		{true, func() map[string]error { return map[string]error{"10.0.0.1:6379": nil} }, http.StatusOK, "ok"},
+		{true, func() map[string]error {
+			return map[string]error{"10.0.0.1:6379": nil, "10.0.0.2:6379": errors.New("connection refused")}
+		}, http.StatusServiceUnavailable, "down"},
+		// the connect at start up failed
+		{true, nil, http.StatusServiceUnavailable, "down"},
+	}
+	for _, tt := range tests {
+		ac := readyContext(t)
+		conf := ac.Config()
+		conf.Redis.Enabled = tt.enabled
+		ac.config.Store(conf)
+		ac.RedisPing = tt.ping
+
+		r := gin.New()
+		r.GET("/readyz", ac.readyz)
+		w := jsonRequest(r, "GET", "/readyz", "")
+
+		var body struct {
+			Checks map[string]checkResult `json:"checks"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		redis, ok := body.Checks["redis"]
+		if w.Code != tt.want || redis.Status != tt.redis || ok != tt.enabled || (ok && !redis.Required) {
+			t.Errorf("Redis enabled %v: status %d, checks %+v", tt.enabled, w.Code, body.Checks)
+		}
+	}
+}
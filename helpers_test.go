@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestContext returns an appContext with the default settings and a
+// logger writing to the returned buffer, no database or templates
+func newTestContext() (*appContext, *bytes.Buffer) {
+	var out bytes.Buffer
+	log := logrus.New()
+	log.Out = &out
+	log.Level = logrus.TraceLevel
+
+	ac := &appContext{Log: ErrorHandler{Log: log, LogLevel: -1, Redactor: &Redactor{}, Slack: NewSlackNotifier()}}
+	conf := DefaultParams()
+	conf.SQL.User = "test"
+	ac.config.Store(conf)
+	return ac, &out
+}
+
+// jsonRequest runs a request against r asking for a JSON answer
+func jsonRequest(r http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Accept", "application/json")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
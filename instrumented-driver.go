@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"math"
+	"strings"
+	"time"
+)
+
+// queryInfoKey holds a queryInfo in the context a query runs with
+type queryInfoKey struct{}
+
+type queryInfo struct {
+	name string
+	log  *LogEntry
+}
+
+// QueryCtx names a query for metrics and logs and ties it to the request: it
+// is cancelled when the client goes away and logs with the request's fields.
+// c may be nil outside a request.
+func (ac *appContext) QueryCtx(c *gin.Context, name string) context.Context {
+	ctx := context.Background()
+	if c != nil {
+		ctx = c.Request.Context()
+	}
+	return context.WithValue(ctx, queryInfoKey{}, queryInfo{name: name, log: ac.Log.WithContext(c)})
+}
+
+// instrumentedConnector wraps the lib/pq connector so every query and exec,
+// inside transactions too, is timed and logged without touching the call
+// sites. Queries run without QueryCtx are named after their verb and table.
+type instrumentedConnector struct {
+	driver.Connector
+	ac *appContext
+}
+
+func (ic instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := ic.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn, ac: ic.ac}, nil
+}
+
+// instrumentedConn forwards the optional driver interfaces lib/pq implements,
+// database/sql only finds them on the outer type
+type instrumentedConn struct {
+	driver.Conn
+	ac *appContext
+}
+
+func (ic *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := ic.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := startQuerySpan(ctx, query)
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	ic.observe(ctx, query, args, time.Since(start), nil, err)
+	endQuerySpan(span, nil, err)
+	return rows, err
+}
+
+func (ic *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := ic.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := startQuerySpan(ctx, query)
+	start := time.Now()
+	res, err := e.ExecContext(ctx, query, args)
+	ic.observe(ctx, query, args, time.Since(start), res, err)
+	endQuerySpan(span, res, err)
+	return res, err
+}
+
+func (ic *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := ic.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return ic.Conn.Prepare(query)
+}
+
+func (ic *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := ic.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return ic.Conn.Begin()
+}
+
+func (ic *instrumentedConn) Ping(ctx context.Context) error {
+	if p, ok := ic.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (ic *instrumentedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if c, ok := ic.Conn.(driver.NamedValueChecker); ok {
+		return c.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (ic *instrumentedConn) ResetSession(ctx context.Context) error {
+	if r, ok := ic.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (ic *instrumentedConn) IsValid() bool {
+	if v, ok := ic.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// observe records a finished query: metrics always, a debug line with the
+// timing and rows affected, or a warning with the statement and redacted
+// arguments when it took longer than SQL.SlowQueryMs
+func (ic *instrumentedConn) observe(ctx context.Context, query string, args []driver.NamedValue, took time.Duration, res driver.Result, err error) {
+	info := queryInfoFrom(ctx, query)
+	if info.log == nil {
+		info.log = ic.ac.Log.WithContext(nil)
+	}
+
+	observeQuery(info.name, took, err)
+
+	fields := logrus.Fields{
+		"query":       info.name,
+		"duration_ms": math.Round(took.Seconds()*1e6) / 1e3,
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	} else if res != nil {
+		if n, err := res.RowsAffected(); err == nil {
+			fields["rows_affected"] = n
+		}
+	}
+
+	conf := ic.ac.Config()
+	if conf.SQL.SlowQueryMs > 0 && took >= time.Duration(conf.SQL.SlowQueryMs)*time.Millisecond {
+		fields["sql"] = strings.Join(strings.Fields(query), " ")
+		fields["args"] = strings.Join(redactArgs(args, conf.Debug > 0), ", ")
+		info.log.WithFields(fields).Msg(2, "Slow query")
+		return
+	}
+	info.log.WithFields(fields).Msg(0, "Query")
+}
+
+func queryInfoFrom(ctx context.Context, query string) queryInfo {
+	info, _ := ctx.Value(queryInfoKey{}).(queryInfo)
+	if info.name == "" {
+		info.name = queryName(query)
+	}
+	return info
+}
+
+// startQuerySpan starts a client span named like the query's metrics, a child
+// of the request span when the query ran with QueryCtx. Arguments are left
+// out of the span, they may hold personal data.
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	info := queryInfoFrom(ctx, query)
+	operation := ""
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	return tracer.Start(ctx, info.name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "postgresql"),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.query.text", strings.Join(strings.Fields(query), " ")),
+		),
+	)
+}
+
+func endQuerySpan(span trace.Span, res driver.Result, err error) {
+	if err != nil && err != driver.ErrSkip {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if res != nil {
+		if n, err := res.RowsAffected(); err == nil {
+			span.SetAttributes(attribute.Int64("db.response.rows_affected", n))
+		}
+	}
+	span.End()
+}
+
+// redactArgs renders query arguments for the log. Strings and bytes may hold
+// names, password hashes or tokens, so only their length is shown unless
+// Debug is set, numbers, booleans and times are shown as they are.
+func redactArgs(args []driver.NamedValue, debug bool) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.Value.(type) {
+		case nil:
+			out[i] = "NULL"
+		case string:
+			if debug {
+				out[i] = fmt.Sprintf("%q", v)
+			} else {
+				out[i] = fmt.Sprintf("[%d chars]", len(v))
+			}
+		case []byte:
+			if debug {
+				out[i] = fmt.Sprintf("%q", v)
+			} else {
+				out[i] = fmt.Sprintf("[%d bytes]", len(v))
+			}
+		case time.Time:
+			out[i] = v.Format(time.RFC3339)
+		default:
+			out[i] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+// queryName labels a query by its verb and first table, "select contacts" or
+// "update users", which keeps the metric series to a handful per table
+func queryName(query string) string {
+	fields := strings.Fields(strings.ToLower(query))
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	verb := fields[0]
+	for i, f := range fields[:len(fields)-1] {
+		if f == "from" || f == "into" || f == "update" {
+			return verb + " " + strings.Trim(fields[i+1], "();")
+		}
+	}
+	return verb
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"strings"
+	"testing"
+	"time"
+)
+
+// instrumentedFakeDB runs db behind the instrumented connector
+func instrumentedFakeDB(ac *appContext, db *fakeDB) *sql.DB {
+	return sql.OpenDB(instrumentedConnector{Connector: fakeConnector{db}, ac: ac})
+}
+
+func queryCount(t *testing.T, name string) uint64 {
+	var m dto.Metric
+	if err := dbQueryDuration.WithLabelValues(name).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func queryErrors(t *testing.T, name string) float64 {
+	var m dto.Metric
+	if err := dbQueryErrors.WithLabelValues(name).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	for _, debug := range []int{0, 1} {
+		ac, out := newTestContext()
+		conf := ac.Config()
+		conf.SQL.SlowQueryMs = 1
+		conf.Debug = debug
+		ac.config.Store(conf)
+		db := instrumentedFakeDB(ac, &fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+			time.Sleep(5 * time.Millisecond)
+			return fakeResult{affected: 1}
+		}})
+
+		before := queryCount(t, "users.set_password")
+		_, err := db.ExecContext(ac.QueryCtx(nil, "users.set_password"),
+			`update users   set password_hash = $1 where id = $2`, "secret-hash", 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := queryCount(t, "users.set_password"); n != before+1 {
+			t.Errorf("users.set_password observed %d times, want %d", n, before+1)
+		}
+
+		logged := out.String()
+		for _, want := range []string{"Slow query", "query=users.set_password", `sql="update users set password_hash = $1 where id = $2"`, "rows_affected=1"} {
+			if !strings.Contains(logged, want) {
+				t.Errorf("debug %d: %s missing from:\n%s", debug, want, logged)
+			}
+		}
+		if shown := strings.Contains(logged, "secret-hash"); shown != (debug > 0) {
+			t.Errorf("debug %d: argument shown %v:\n%s", debug, shown, logged)
+		}
+		if debug == 0 && !strings.Contains(logged, `args="[11 chars], 42"`) {
+			t.Errorf("arguments not redacted:\n%s", logged)
+		}
+	}
+}
+
+func TestQueryMetrics(t *testing.T) {
+	ac, out := newTestContext()
+	failed := errors.New("deadlock detected")
+	db := instrumentedFakeDB(ac, &fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "delete") {
+			return fakeResult{err: failed}
+		}
+		return fakeRows([]string{"id"}, []driver.Value{"c1"})
+	}})
+
+	named := queryErrors(t, "contacts.delete")
+	unnamed := queryErrors(t, "delete contacts")
+	selects := queryCount(t, "select contacts")
+
+	if _, err := db.ExecContext(ac.QueryCtx(nil, "contacts.delete"), `delete from contacts where id = $1`, "c1"); err != failed {
+		t.Fatalf("exec returned %v", err)
+	}
+	if _, err := db.Exec(`delete from contacts where id = $1`, "c1"); err != failed {
+		t.Fatalf("exec returned %v", err)
+	}
+	rows, err := db.Query(`select id from contacts`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if n := queryErrors(t, "contacts.delete"); n != named+1 {
+		t.Errorf("contacts.delete errors %v, want %v", n, named+1)
+	}
+	if n := queryErrors(t, "delete contacts"); n != unnamed+1 {
+		t.Errorf("delete contacts errors %v, want %v", n, unnamed+1)
+	}
+	if n := queryCount(t, "select contacts"); n != selects+1 {
+		t.Errorf("select contacts observed %d times, want %d", n, selects+1)
+	}
+	if !strings.Contains(out.String(), `error="deadlock detected"`) || strings.Contains(out.String(), "Slow query") {
+		t.Errorf("failed queries logged as:\n%s", out.String())
+	}
+}
+
+func TestQueryName(t *testing.T) {
+	tests := map[string]string{
+		"":                                      "unknown",
+		"select id from contacts where id = $1": "select contacts",
+		"insert into address_books (name) values": "insert address_books",
+		"UPDATE users SET role = $1":              "update users",
+		"delete from sessions;":                   "delete sessions",
+		"begin":                                   "begin",
+	}
+	for query, want := range tests {
+		if got := queryName(query); got != want {
+			t.Errorf("queryName(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"log/syslog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// logSink is a logrus hook writing every entry at or above its level to one
+// destination. Level and format may change on a config reload, the
+// destination is fixed for the life of the process.
+type logSink struct {
+	mu        sync.Mutex
+	name      string
+	level     logrus.Level
+	formatter logrus.Formatter
+	out       io.Writer
+	syslog    *syslog.Writer
+	reopen    func() error // nil when the destination cannot be reopened
+}
+
+func logrusLevel(level int) logrus.Level {
+	switch level {
+	case -1:
+		return logrus.TraceLevel
+	case 0:
+		return logrus.DebugLevel
+	case 1:
+		return logrus.InfoLevel
+	case 2:
+		return logrus.WarnLevel
+	case 3:
+		return logrus.ErrorLevel
+	case 4:
+		return logrus.FatalLevel
+	case 5:
+		return logrus.PanicLevel
+	}
+	return logrus.InfoLevel
+}
+
+func logFormatter(format string) logrus.Formatter {
+	if format == "json" {
+		return &logrus.JSONFormatter{}
+	}
+	return &logrus.TextFormatter{
+		FullTimestamp: true,
+	}
+}
+
+// openLogSink opens the destination conf describes
+func openLogSink(conf LogSink) (*logSink, error) {
+	s := &logSink{name: conf.Type}
+
+	switch conf.Type {
+	case "stderr":
+		s.out = os.Stderr
+	case "file":
+		s.name = conf.Path
+		if conf.MaxSizeMB > 0 || conf.MaxAgeDays > 0 || conf.MaxBackups > 0 {
+			lj := &lumberjack.Logger{
+				Filename:   conf.Path,
+				MaxSize:    conf.MaxSizeMB,
+				MaxAge:     conf.MaxAgeDays,
+				MaxBackups: conf.MaxBackups,
+				Compress:   conf.Compress,
+				LocalTime:  true,
+			}
+			s.out = lj
+			// lumberjack opens the file again on the next write
+			s.reopen = lj.Close
+		} else {
+			f, err := openLogFile(conf.Path)
+			if err != nil {
+				return nil, err
+			}
+			s.out = f
+			s.reopen = func() error {
+				next, err := openLogFile(conf.Path)
+				if err != nil {
+					return err
+				}
+				prev := s.out.(*os.File)
+				s.out = next
+				return prev.Close()
+			}
+		}
+	case "syslog":
+		tag := conf.Tag
+		if tag == "" {
+			tag = "contactmanager"
+		}
+		w, err := syslog.Dial(conf.Network, conf.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+		if err != nil {
+			return nil, err
+		}
+		s.name = "syslog " + conf.Address
+		s.syslog = w
+	default:
+		return nil, errors.New("unknown log sink type " + conf.Type)
+	}
+
+	s.configure(conf)
+	return s, nil
+}
+
+// openAccessLog opens a file sink writing each message as is, so common and
+// combined access lines stay valid CLF for log analysers
+func openAccessLog(path string) (*logSink, error) {
+	trace := -1
+	s, err := openLogSink(LogSink{Type: "file", Path: path, Level: &trace})
+	if err != nil {
+		return nil, err
+	}
+	s.formatter = messageFormatter{}
+	return s, nil
+}
+
+// messageFormatter writes the message alone, without time, level or fields
+type messageFormatter struct{}
+
+func (messageFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	return []byte(entry.Message + "\n"), nil
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+}
+
+// configure applies the settings that may change on a reload
+func (s *logSink) configure(conf LogSink) {
+	s.mu.Lock()
+	s.level = logrusLevel(*conf.Level)
+	s.formatter = logFormatter(conf.Format)
+	s.mu.Unlock()
+}
+
+// Levels reports every level, Fire filters so a reload can change the level
+func (s *logSink) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (s *logSink) Fire(entry *logrus.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry.Level > s.level {
+		return nil
+	}
+	line, err := s.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	if s.syslog == nil {
+		_, err = s.out.Write(line)
+		return err
+	}
+
+	// syslog adds its own timestamp and severity
+	msg := strings.TrimRight(string(line), "\n")
+	switch entry.Level {
+	case logrus.PanicLevel:
+		return s.syslog.Emerg(msg)
+	case logrus.FatalLevel:
+		return s.syslog.Crit(msg)
+	case logrus.ErrorLevel:
+		return s.syslog.Err(msg)
+	case logrus.WarnLevel:
+		return s.syslog.Warning(msg)
+	case logrus.InfoLevel:
+		return s.syslog.Info(msg)
+	}
+	return s.syslog.Debug(msg)
+}
+
+func (s *logSink) Reopen() error {
+	if s.reopen == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reopen()
+}
+
+// Reopen closes and reopens every file sink and the access log, for use after logrotate has
+// moved the files away
+func (e *ErrorHandler) Reopen() {
+	sinks := e.sinks
+	if e.access != nil {
+		sinks = append(sinks[:len(sinks):len(sinks)], e.access)
+	}
+	for _, s := range sinks {
+		if err := s.Reopen(); err != nil {
+			e.Msg(3, "Reopening log [ "+s.name+" ] failed: "+err.Error())
+		}
+	}
+}
+
+// ReopenOnSignal reopens the log files on SIGUSR1, e.g. from a logrotate
+// postrotate script: kill -USR1 $(pidof contactmanager)
+func (e *ErrorHandler) ReopenOnSignal() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+
+	go func() {
+		for range usr1 {
+			e.Reopen()
+			e.Msg(1, "Log files reopened")
+		}
+	}()
+}
@@ -1,78 +1,166 @@
 package main
 
 import (
-	"./GinHTMLRender"
+	"contactmanager/GinHTMLRender"
+	"contactmanager/RedisConnector"
 	"database/sql"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
 	"net/http"
+	"os"
+	"sync/atomic"
 )
 
 type appContext struct {
 	DB         *sql.DB
-	ConfigData Params
 	Log        ErrorHandler
+	OIDC       *OIDCClient
+	Templates  *GinHTMLRender.Render
+	Redis      *RedisConnector.Client  // nil unless Redis.Enabled
+	RedisPing  func() map[string]error // per node ping, nil while Redis is not in use
+	config     atomic.Value            // live Params, swapped on reload
+	dbHealthy  int32                   // 1 while the last DB check succeeded
+	dbChecking int32                   // 1 while a CheckDB started by a failed query runs
+	configFile string
+	configArgs []string
+}
+
+// Config returns the live configuration
+func (ac *appContext) Config() Params {
+	return ac.config.Load().(Params)
 }
 
 func main() {
+	configFile, configArgs, command, err := ParseCommandLine(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	os.Exit(RunCommand(configFile, configArgs, command))
+}
+
+// NewAppContext loads the configuration and starts logging, the wiring every
+// command shares
+func NewAppContext(configFile string, configArgs []string) (*appContext, error) {
 	context := &appContext{
 		DB:         nil,
-		ConfigData: LoadConfigFile("config.json"),
+		configFile: configFile,
+		configArgs: configArgs,
 	}
 
-	context.Log.InitLog(&context.ConfigData)
+	config, err := LoadConfig(context.configFile, context.configArgs)
+	if err != nil {
+		return nil, err
+	}
+	context.config.Store(config)
+
+	context.Log.InitLog(&config)
+
+	return context, nil
+}
+
+// serve runs the web server until it fails or is told to stop, returning nil
+// after a clean shutdown
+func serve(context *appContext) error {
+	config := context.Config()
+	context.WatchConfig()
+	context.Log.ReopenOnSignal()
 
 	context.Log.Msg(1, "Starting Advanced.ID web server ")
 
 	InitDB(context)
+	context.MonitorDB()
+	context.SessionMaintenance()
+	InitOIDC(context)
+	// deferred calls run in reverse, traces are flushed after Redis closed
+	defer InitTracing(context)()
+	defer InitRedis(context)()
 
 	// context.LoadAppDefaults()
 
-	r := gin.Default()
+	// gin.Default's logger writes to stdout and its recovery only prints the
+	// stack, AccessLog and Recovery go through ErrorHandler instead
+	r := gin.New()
 	htmlRender := GinHTMLRender.New()
 	htmlRender.Debug = gin.IsDebugging()
 	htmlRender.Layout = "layouts/default"
 	htmlRender.TemplatesDir = "templates/" // default
 	htmlRender.Ext = ".html"               // default
+	htmlRender.Observe = observeTemplate
+	htmlRender.Trace = true
 
 	// tell gin to use our render
-	r.HTMLRender = htmlRender.Create()
+	context.Templates = htmlRender.Create()
+	r.HTMLRender = context.Templates
 
 	r.RedirectTrailingSlash = true
 	r.RedirectFixedPath = true
 
-	r.StaticFS("/assets", http.Dir("./assets"))
-
-	r.GET("/", context.ShowIndex)
-	r.GET("/index", context.ShowIndex)
-	r.GET("/index.html", context.ShowIndex)
-	r.POST("/formData", context.uploadContact)
-	r.POST("/saveUpdate", context.saveContact)
-	r.POST("/deleteContact", context.deleteContact)
-	r.POST("/editContact", context.editContact)
-
-	_ = r.Run(context.ConfigData.ListenIP + ":" + context.ConfigData.ListenPort)
-}
-
-func InitDB(c *appContext) {
-	var err error
-
-	connStr := "postgres://" + c.ConfigData.SQL.User + ":" + c.ConfigData.SQL.Password + "@" +
-		c.ConfigData.SQL.Host + ":" + c.ConfigData.SQL.Port + "/" +
-		c.ConfigData.SQL.DBname + "?sslmode=disable"
-
-	c.Log.Msg(0, "PG Connection String: "+connStr)
+	r.Use(context.Recovery, context.Tracing, context.RequestLogger, context.AccessLog, context.Metrics, context.Recovery)
 
-	c.DB, err = sql.Open("postgres", connStr)
-	if err != nil {
-		c.Log.Msg(5, err.Error())
-	}
-
-	err = c.DB.Ping()
-	if err != nil {
-		c.Log.Msg(5, "DB Ping failed: "+err.Error())
+	r.StaticFS("/assets", http.Dir("./assets"))
+	r.GET("/metrics", MetricsHandler())
+	r.GET("/healthz", context.healthz)
+	r.GET("/readyz", context.readyz)
+
+	// registered after the static files so assets still load in maintenance mode
+	r.Use(context.RequireDB)
+
+	r.GET("/login", context.ShowLogin)
+	r.POST("/login", context.login)
+	r.GET("/logout", context.logout)
+	r.GET("/auth/oidc/login", context.oidcLogin)
+	r.GET("/auth/oidc/callback", context.oidcCallback)
+
+	mfa := r.Group("/", context.RequireSession)
+	mfa.GET("/login/2fa", context.ShowTwoFactor)
+	mfa.POST("/login/2fa", context.verifyTwoFactor)
+	mfa.GET("/account/2fa", context.ShowEnrolTwoFactor)
+	mfa.POST("/account/2fa", context.enrolTwoFactor)
+
+	auth := r.Group("/", context.RequireUser)
+	read := context.RequirePermission(PermContactRead)
+	write := context.RequirePermission(PermContactWrite)
+
+	bookRead := context.RequireBook(BookRead)
+	bookWrite := context.RequireBook(BookWrite)
+
+	auth.GET("/", read, bookRead, context.ShowIndex)
+	auth.GET("/index", read, bookRead, context.ShowIndex)
+	auth.GET("/index.html", read, bookRead, context.ShowIndex)
+	auth.POST("/formData", write, bookWrite, context.uploadContact)
+	auth.POST("/saveUpdate", write, bookWrite, context.saveContact)
+	auth.POST("/deleteContact", context.RequirePermission(PermContactDelete), bookWrite, context.deleteContact)
+	auth.POST("/importContacts", context.RequirePermission(PermContactImport), context.RequireBookFor(BookWrite, PermContactImport), context.importContacts)
+	auth.POST("/editContact", write, bookWrite, context.editContact)
+
+	account := auth.Group("/account", context.RejectAPIToken)
+	account.POST("/2fa/disable", context.disableTwoFactor)
+	account.GET("/tokens", context.listTokens)
+	account.POST("/tokens", context.createToken)
+	account.POST("/tokens/revoke", context.revokeToken)
+
+	auth.GET("/books", read, context.listBooks)
+	auth.POST("/books", write, context.createBook)
+	auth.POST("/books/members", read, context.RequireBook(BookAdmin), context.setMember)
+	auth.POST("/books/members/remove", read, context.RequireBook(BookAdmin), context.removeMember)
+
+	admin := auth.Group("/admin", context.RequirePermission(PermAdmin))
+	admin.GET("/users", context.listUsers)
+	admin.POST("/users/role", context.assignRole)
+	admin.GET("/settings/2fa", context.getTwoFactorSetting)
+	admin.POST("/settings/2fa", context.setTwoFactorSetting)
+
+	r.NoRoute(func(c *gin.Context) {
+		context.AbortError(ErrNotFound, c)
+	})
+
+	if err := context.listenAndServe(config.ListenIP+":"+config.ListenPort, r); err != nil {
+		context.Log.Msg(3, "Server stopped: "+err.Error())
+		return err
 	}
-
-	c.Log.Msg(0, "Successfully connected to database [ "+
-		c.ConfigData.SQL.DBname+" ]")
+	context.Log.Msg(1, "Server stopped")
+	return nil
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "contactmanager"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency by query name.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"query"})
+
+	dbQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_query_errors_total",
+		Help:      "Failed database queries by query name.",
+	}, []string{"query"})
+
+	templateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "template_render_duration_seconds",
+		Help:      "HTML template render time by template.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"template"})
+
+	panicsRecovered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "panics_recovered_total",
+		Help:      "Panics recovered while handling a request, by route.",
+	}, []string{"route"})
+)
+
+// Metrics records the count and latency of every request. Requests that match
+// no route share one label so scanners cannot blow up the series count.
+func (ac *appContext) Metrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	status := strconv.Itoa(c.Writer.Status())
+
+	httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+	httpDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+}
+
+// MetricsHandler serves the default registry, which the RedisConnector
+// collectors register with as well
+func MetricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// registerDBStats exports the sql.DB pool statistics
+func registerDBStats(db *sql.DB, dbName string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, dbName))
+}
+
+func observeQuery(name string, took time.Duration, err error) {
+	dbQueryDuration.WithLabelValues(name).Observe(took.Seconds())
+	if err != nil {
+		dbQueryErrors.WithLabelValues(name).Inc()
+	}
+}
+
+func observeTemplate(name string, took time.Duration) {
+	templateDuration.WithLabelValues(name).Observe(took.Seconds())
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// migrationsDir holds the SQL files. initial_schema.sql creates the database
+// and role and is run once by hand with psql, everything after it is applied
+// in order by `contactmanager migrate`.
+const migrationsDir = "SQL/"
+
+// undefinedTable is the SQLSTATE for a missing table
+const undefinedTable = "42P01"
+
+var migrations = []string{
+	"users_roles.sql",
+	"address_books.sql",
+	"oidc.sql",
+	"two_factor.sql",
+	"api_tokens.sql",
+	"two_factor_limits.sql",
+}
+
+// migrationSQL reads a migration without its psql meta-commands (\c ...)
+func migrationSQL(name string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(migrationsDir, name))
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), `\`) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (ac *appContext) ensureMigrationsTable() error {
+	_, err := ac.DB.Exec(`
+		create table if not exists schema_migrations(
+			name text primary key,
+			applied timestamptz not null default now()
+		)`)
+	return err
+}
+
+// PendingMigrations lists the migrations not yet applied, in order. It only
+// reads, before the first migrate every migration is pending. c may be nil
+// outside a request, the query is given SQL.HealthCheckSeconds either way.
+func (ac *appContext) PendingMigrations(c *gin.Context) ([]string, error) {
+	timeout := time.Duration(ac.Config().SQL.HealthCheckSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(ac.QueryCtx(c, "migrations.applied"), timeout)
+	defer cancel()
+
+	rows, err := ac.DB.QueryContext(ctx, `select name from schema_migrations`)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == undefinedTable {
+		return append([]string{}, migrations...), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		applied[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, name := range migrations {
+		if !applied[name] {
+			pending = append(pending, name)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration, each in its own transaction
+func (ac *appContext) Migrate() ([]string, error) {
+	if err := ac.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	pending, err := ac.PendingMigrations(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []string
+	for _, name := range pending {
+		query, err := migrationSQL(name)
+		if err != nil {
+			return done, err
+		}
+
+		tx, err := ac.DB.Begin()
+		if err != nil {
+			return done, err
+		}
+		if _, err := tx.ExecContext(ac.QueryCtx(nil, "migrations."+name), query); err != nil {
+			tx.Rollback()
+			return done, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		if _, err := tx.Exec(`insert into schema_migrations (name) values ($1)`, name); err != nil {
+			tx.Rollback()
+			return done, err
+		}
+		if err := tx.Commit(); err != nil {
+			return done, err
+		}
+
+		ac.Log.Msg(1, "Applied migration [ "+name+" ]")
+		done = append(done, name)
+	}
+	return done, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql/driver"
+	"github.com/lib/pq"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPendingMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  fakeResult
+		pending []string
+	}{
+		{"fresh database", fakeResult{err: &pq.Error{Code: undefinedTable, Message: `relation "schema_migrations" does not exist`}}, migrations},
+		{"partly migrated", fakeRows([]string{"name"}, []driver.Value{"users_roles.sql"}, []driver.Value{"address_books.sql"}), migrations[2:]},
+	}
+	for _, tt := range tests {
+		ac, _ := newTestContext()
+		db := &fakeDB{respond: func(query string, args []driver.Value) fakeResult {
+			if strings.HasPrefix(query, "select name from schema_migrations") {
+				return tt.result
+			}
+			return fakeResult{err: &pq.Error{Message: "unexpected query: " + query}}
+		}}
+		ac.DB = db.open()
+
+		pending, err := ac.PendingMigrations(nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(pending, tt.pending) {
+			t.Errorf("%s: pending %v, want %v", tt.name, pending, tt.pending)
+		}
+		if db.ran("create") {
+			t.Errorf("%s: checking migrations changed the schema", tt.name)
+		}
+	}
+}
+
+func TestMigrationsSeedNoAccount(t *testing.T) {
+	for _, name := range migrations {
+		query, err := migrationSQL(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(query, "insert into users") || strings.Contains(strings.ToLower(query), "pgcrypto") {
+			t.Errorf("%s seeds an account", name)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"net/http"
+	"strings"
+)
+
+const oidcFlowCookie = "oidc_flow"
+
+// roleRank decides which role wins when several claim values map to a role
+var roleRank = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+type OIDCClient struct {
+	Issuer   string
+	Config   oauth2.Config
+	Verifier *oidc.IDTokenVerifier
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Nonce         string `json:"nonce"`
+}
+
+// InitOIDC discovers the configured identity provider. SSO stays disabled when
+// no issuer is configured.
+func InitOIDC(c *appContext) {
+	conf := c.Config().OIDC
+	if conf.Issuer == "" {
+		return
+	}
+
+	provider, err := oidc.NewProvider(context.Background(), conf.Issuer)
+	if err != nil {
+		c.Log.Msg(3, "OIDC discovery failed, SSO disabled: "+err.Error())
+		return
+	}
+
+	c.OIDC = &OIDCClient{
+		Issuer: conf.Issuer,
+		Config: oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		Verifier: provider.Verifier(&oidc.Config{ClientID: conf.ClientID}),
+	}
+
+	c.Log.Msg(1, "OIDC login enabled for [ "+conf.Issuer+" ]")
+}
+
+// oidcLogin starts an authorization code flow with PKCE. State, nonce and the
+// code verifier travel in a short lived http only cookie.
+func (ac *appContext) oidcLogin(c *gin.Context) {
+	if ac.OIDC == nil {
+		ac.AbortError(ErrSSODisabled, c)
+		return
+	}
+
+	state, err := newSessionToken()
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	nonce, err := newSessionToken()
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	ac.setCookie(c, oidcFlowCookie, state+"."+nonce+"."+verifier, 600, "/auth/oidc")
+	c.Redirect(http.StatusFound, ac.OIDC.Config.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce)))
+}
+
+func (ac *appContext) oidcCallback(c *gin.Context) {
+	if ac.OIDC == nil {
+		ac.AbortError(ErrSSODisabled, c)
+		return
+	}
+
+	flow, err := c.Cookie(oidcFlowCookie)
+	ac.setCookie(c, oidcFlowCookie, "", -1, "/auth/oidc")
+	parts := strings.Split(flow, ".")
+	if err != nil || len(parts) != 3 || c.Query("state") != parts[0] {
+		ac.AbortError(ErrVerification.Because("OIDC state mismatch"), c)
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	if e := c.Query("error"); e != "" {
+		ac.AbortError(ErrVerification.Because("OIDC provider error: "+e+" "+c.Query("error_description")), c)
+		return
+	}
+
+	token, err := ac.OIDC.Config.Exchange(c.Request.Context(), c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		ac.AbortError(ErrVerification.Because("OIDC code exchange failed: "+err.Error()), c)
+		return
+	}
+	rawID, ok := token.Extra("id_token").(string)
+	if !ok {
+		ac.AbortError(ErrVerification.Because("OIDC response has no id_token"), c)
+		return
+	}
+	idToken, err := ac.OIDC.Verifier.Verify(c.Request.Context(), rawID)
+	if err != nil {
+		ac.AbortError(ErrVerification.Because("OIDC id_token invalid: "+err.Error()), c)
+		return
+	}
+
+	var claims oidcClaims
+	var allClaims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		ac.AbortError(ErrVerification.Wrap(err), c)
+		return
+	}
+	if err := idToken.Claims(&allClaims); err != nil {
+		ac.AbortError(ErrVerification.Wrap(err), c)
+		return
+	}
+	if claims.Nonce != nonce {
+		ac.AbortError(ErrVerification.Because("OIDC nonce mismatch"), c)
+		return
+	}
+	if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+		ac.AbortError(ErrAccountNotAllowed.Because("OIDC account has no verified email"), c)
+		return
+	}
+	if !ac.oidcDomainAllowed(claims.Email) {
+		ac.AbortError(ErrAccountNotAllowed.Because("email domain not allowed: "+claims.Email), c)
+		return
+	}
+
+	userID, err := ac.provisionOIDCUser(c, idToken.Subject, claims.Email, ac.oidcRole(allClaims))
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	if err := ac.EnsurePersonalBook(c, userID, claims.Email); err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+
+	// the require_2fa policy covers SSO logins as it does password logins
+	pending, err := ac.pendingSecondFactor(c, userID)
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+
+	ac.Log.WithContext(c).Msg(1, "OIDC login for [ "+claims.Email+" ]")
+	ac.startSession(c, userID, pending)
+}
+
+func (ac *appContext) oidcDomainAllowed(email string) bool {
+	domains := ac.Config().OIDC.AllowedDomains
+	if len(domains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	for _, d := range domains {
+		if strings.EqualFold(email[at+1:], d) {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcRole maps the configured role claim to the highest ranked app role.
+// Returns an empty string when no claim value is mapped.
+func (ac *appContext) oidcRole(claims map[string]interface{}) string {
+	conf := ac.Config().OIDC
+	if conf.RoleClaim == "" {
+		return ""
+	}
+
+	var values []string
+	switch v := claims[conf.RoleClaim].(type) {
+	case string:
+		values = append(values, v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	role := ""
+	for _, v := range values {
+		if mapped, ok := conf.RoleMapping[v]; ok && roleRank[mapped] > roleRank[role] {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// provisionOIDCUser finds the user for issuer+subject, creating them on first
+// login. A mapped role overrides the stored one, new users without one get
+// DefaultRole. A disabled user gets ErrAccountNotAllowed, an email already
+// used as a local username ErrAccountConflict, accounts are never linked
+// implicitly.
+func (ac *appContext) provisionOIDCUser(c *gin.Context, subject string, email string, role string) (string, error) {
+	var userID string
+	var enabled bool
+	query := `select id, enabled from users where oidc_issuer = $1 and oidc_subject = $2`
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "users.oidc_lookup"), query, ac.OIDC.Issuer, subject).Scan(&userID, &enabled)
+
+	switch err {
+	case nil:
+		if !enabled {
+			return "", ErrAccountNotAllowed.Because("user " + userID + " is disabled")
+		}
+		if role != "" {
+			_, err = ac.DB.ExecContext(ac.QueryCtx(c, "users.oidc_role"), `update users set role = $1 where id = $2`, role, userID)
+		}
+		return userID, err
+	case sql.ErrNoRows:
+	default:
+		return "", err
+	}
+
+	if role == "" {
+		role = ac.Config().OIDC.DefaultRole
+	}
+	if !ValidRole(role) {
+		role = RoleViewer
+	}
+
+	// '!' is never a valid bcrypt hash so SSO users cannot use the password form
+	query = `
+		insert into users (username, password_hash, role, oidc_issuer, oidc_subject)
+		values ($1, '!', $2, $3, $4)
+		on conflict (username) do nothing
+		returning id`
+	err = ac.DB.QueryRowContext(ac.QueryCtx(c, "users.oidc_provision"), query, email, role, ac.OIDC.Issuer, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrAccountConflict.Because("username " + email + " is taken by another account")
+	}
+	if err == nil {
+		ac.Log.WithContext(c).Msg(1, "Provisioned user [ "+email+" ] with role [ "+role+" ]")
+	}
+	return userID, err
+}
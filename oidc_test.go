@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubIssuer is an OpenID provider serving discovery, keys and a token
+// endpoint that hands out an ID token with the claims set by the test
+type stubIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims map[string]interface{}
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                s.URL,
+			"authorization_endpoint":                s.URL + "/auth",
+			"token_endpoint":                        s.URL + "/token",
+			"jwks_uri":                              s.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     s.idToken(t),
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stubIssuer) setClaims(claims map[string]interface{}) {
+	s.mu.Lock()
+	s.claims = claims
+	s.mu.Unlock()
+}
+
+func (s *stubIssuer) idToken(t *testing.T) string {
+	s.mu.Lock()
+	claims := map[string]interface{}{
+		"iss": s.URL,
+		"aud": "contactmanager",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+	for k, v := range s.claims {
+		claims[k] = v
+	}
+	s.mu.Unlock()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// oidcUsers answers the statements of an SSO login, users maps subjects to
+// their id and enabled flag
+func oidcUsers(users map[string][]driver.Value) func(string, []driver.Value) fakeResult {
+	return func(query string, args []driver.Value) fakeResult {
+		switch {
+		case strings.HasPrefix(query, "select id, enabled from users"):
+			if u, ok := users[args[1].(string)]; ok {
+				return fakeRows([]string{"id", "enabled"}, u)
+			}
+			return fakeRows([]string{"id", "enabled"})
+		case strings.HasPrefix(query, "insert into users"):
+			if args[0] == "taken@example.com" {
+				// on conflict do nothing returns no row
+				return fakeRows([]string{"id"})
+			}
+			return fakeRows([]string{"id"}, []driver.Value{"new-user"})
+		case strings.HasPrefix(query, "select role, totp_enabled from users"):
+			return fakeRows([]string{"role", "totp_enabled"}, []driver.Value{RoleViewer, args[0] == "mfa-user"})
+		case strings.HasPrefix(query, "select value from settings"):
+			return fakeRows([]string{"value"})
+		case strings.HasPrefix(query, "update users set role"),
+			strings.HasPrefix(query, "with book as"),
+			strings.HasPrefix(query, "insert into sessions"):
+			return fakeResult{affected: 1}
+		}
+		return fakeResult{err: errors.New("unexpected query: " + query)}
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newStubIssuer(t)
+
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		badNonce bool
+		code     string
+		want     int
+		location string
+		inserted bool
+	}{
+		{
+			name:     "first login provisions the user",
+			claims:   map[string]interface{}{"sub": "new", "email": "new@example.com", "email_verified": true},
+			code:     "good-code",
+			want:     http.StatusFound,
+			location: "/",
+			inserted: true,
+		},
+		{
+			name:     "known user logs in",
+			claims:   map[string]interface{}{"sub": "known", "email": "known@example.com"},
+			code:     "good-code",
+			want:     http.StatusFound,
+			location: "/",
+		},
+		{
+			name:     "enrolled user still owes the second factor",
+			claims:   map[string]interface{}{"sub": "mfa", "email": "mfa@example.com"},
+			code:     "good-code",
+			want:     http.StatusFound,
+			location: "/login/2fa",
+		},
+		{
+			name:   "disabled user is refused",
+			claims: map[string]interface{}{"sub": "disabled", "email": "off@example.com"},
+			code:   "good-code",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:     "email of a local username is refused",
+			claims:   map[string]interface{}{"sub": "clash", "email": "taken@example.com"},
+			code:     "good-code",
+			want:     http.StatusConflict,
+			inserted: true,
+		},
+		{
+			name:   "unverified email is refused",
+			claims: map[string]interface{}{"sub": "new", "email": "new@example.com", "email_verified": false},
+			code:   "good-code",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "other domain is refused",
+			claims: map[string]interface{}{"sub": "new", "email": "new@elsewhere.org"},
+			code:   "good-code",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:     "replayed nonce is refused",
+			claims:   map[string]interface{}{"sub": "new", "email": "new@example.com"},
+			badNonce: true,
+			code:     "good-code",
+			want:     http.StatusNotAcceptable,
+		},
+		{
+			name:   "failed code exchange is refused",
+			claims: map[string]interface{}{"sub": "new", "email": "new@example.com"},
+			code:   "bad-code",
+			want:   http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac, _ := newTestContext()
+			conf := ac.Config()
+			conf.OIDC.Issuer = issuer.URL
+			conf.OIDC.ClientID = "contactmanager"
+			conf.OIDC.RedirectURL = "http://app.test/auth/oidc/callback"
+			conf.OIDC.AllowedDomains = []string{"example.com"}
+			ac.config.Store(conf)
+
+			db := &fakeDB{respond: oidcUsers(map[string][]driver.Value{
+				"known":    {"known-user", true},
+				"mfa":      {"mfa-user", true},
+				"disabled": {"disabled-user", false},
+			})}
+			ac.DB = db.open()
+
+			InitOIDC(ac)
+			if ac.OIDC == nil {
+				t.Fatal("discovery against the stub issuer failed")
+			}
+
+			r := gin.New()
+			r.GET("/auth/oidc/login", ac.oidcLogin)
+			r.GET("/auth/oidc/callback", ac.oidcCallback)
+
+			login := httptest.NewRecorder()
+			r.ServeHTTP(login, httptest.NewRequest("GET", "/auth/oidc/login", nil))
+			if login.Code != http.StatusFound {
+				t.Fatalf("login status %d", login.Code)
+			}
+			redirect, _ := url.Parse(login.Header().Get("Location"))
+			if redirect.Query().Get("code_challenge_method") != "S256" {
+				t.Fatal("login does not use PKCE")
+			}
+			flow := login.Result().Cookies()[0]
+
+			claims := map[string]interface{}{"nonce": redirect.Query().Get("nonce")}
+			if tt.badNonce {
+				claims["nonce"] = "other"
+			}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			issuer.setClaims(claims)
+
+			req := httptest.NewRequest("GET", "/auth/oidc/callback?state="+redirect.Query().Get("state")+"&code="+tt.code, nil)
+			req.Header.Set("Accept", "application/json")
+			req.AddCookie(flow)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("callback status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.location != "" && w.Header().Get("Location") != tt.location {
+				t.Errorf("redirected to %q, want %q", w.Header().Get("Location"), tt.location)
+			}
+			if db.ran("insert into users") != tt.inserted {
+				t.Errorf("user inserted = %v, want %v", !tt.inserted, tt.inserted)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	ac, _ := newTestContext()
+	ac.OIDC = &OIDCClient{}
+
+	r := gin.New()
+	r.GET("/auth/oidc/callback", ac.oidcCallback)
+	req := httptest.NewRequest("GET", "/auth/oidc/callback?state=forged&code=x", nil)
+	req.AddCookie(&http.Cookie{Name: oidcFlowCookie, Value: "state.nonce.verifier"})
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusNotAcceptable)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Recovery turns a panic in a handler, a level 5 Msg or a template GinHTMLRender
+// cannot execute, into a logged and alerted 500 with the request ID the user
+// can quote. It is registered twice: innermost, after RequestLogger and
+// Metrics so the log line carries the request fields and the 500 is counted
+// like any other response, and outermost so a panic in the other middleware
+// is answered too.
+func (ac *appContext) Recovery(c *gin.Context) {
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+
+		// skip runtime.Callers, stackTrace, this function and gopanic, the
+		// first frame left is the one that panicked
+		stack := stackTrace(4)
+
+		if brokenPipe(rec) {
+			// the client went away, there is no one to answer and nothing to alert
+			ac.Log.WithContext(c).WithFields(logrus.Fields{"error": fmt.Sprint(rec)}).Msg(1, "Client connection lost")
+			c.Abort()
+			return
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		panicsRecovered.WithLabelValues(route).Inc()
+
+		// a level 5 Msg panics with its entry once it has been logged and
+		// alerted, only the stack is news
+		level := 3
+		cause := fmt.Sprint(rec)
+		if entry, ok := rec.(*logrus.Entry); ok {
+			level, cause = 2, entry.Message
+		}
+		ac.Log.WithContext(c).WithFields(logrus.Fields{
+			"route": route,
+			"error": cause,
+			"stack": stack,
+		}).Msg(level, "Panic recovered")
+
+		if c.Writer.Written() {
+			// part of the response is out already, it cannot become an error page
+			c.Abort()
+			return
+		}
+
+		var detail string
+		if ac.Config().Debug > 0 {
+			detail = ac.Log.Redactor.Redact(cause)
+		}
+		ac.writePanicError(detail, c)
+		c.Abort()
+	}()
+
+	c.Next()
+}
+
+// writePanicError renders the 500 page, falling back to plain text when the
+// template is what panicked
+func (ac *appContext) writePanicError(detail string, c *gin.Context) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			ac.Log.WithContext(c).WithFields(logrus.Fields{"error": fmt.Sprint(rec)}).Msg(2, "Error page failed")
+			if !c.Writer.Written() {
+				c.String(http.StatusInternalServerError, ErrInternal.Message+" Reference: "+RequestID(c))
+			}
+		}
+	}()
+
+	writeError(ErrInternal, detail, c)
+}
+
+// brokenPipe reports whether rec is a write to a connection the client closed
+func brokenPipe(rec interface{}) bool {
+	err, ok := rec.(error)
+	if !ok {
+		return false
+	}
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		var sysErr *os.SyscallError
+		if errors.As(opErr.Err, &sysErr) {
+			msg := strings.ToLower(sysErr.Error())
+			return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+		}
+	}
+	return false
+}
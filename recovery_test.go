@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	dto "github.com/prometheus/client_model/go"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func panicsCounted(t *testing.T, route string) float64 {
+	var m dto.Metric
+	if err := panicsRecovered.WithLabelValues(route).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestRecovery(t *testing.T) {
+	ac, out := newTestContext()
+	srv, posts := slackWebhook(t)
+	ac.Log.Slack.Configure(srv.URL, "", time.Hour)
+
+	r := gin.New()
+	r.Use(ac.Recovery, func(c *gin.Context) {
+		c.Next()
+		if c.Request.URL.Path == "/outer" {
+			panic("middleware failed")
+		}
+	}, ac.RequestLogger, ac.Recovery)
+	r.GET("/panic", func(c *gin.Context) {
+		panic("handler failed")
+	})
+	r.GET("/msg", func(c *gin.Context) {
+		ac.Log.WithContext(c).Msg(5, "invariant broken")
+	})
+	r.GET("/pipe", func(c *gin.Context) {
+		panic(syscall.EPIPE)
+	})
+	r.GET("/outer", func(c *gin.Context) {})
+
+	tests := []struct {
+		path    string
+		want    int
+		counted float64
+		alert   string
+		logged  string
+	}{
+		{"/panic", http.StatusInternalServerError, 1, "ERROR: Panic recovered", "level=error"},
+		{"/msg", http.StatusInternalServerError, 1, "PANIC: invariant broken", "level=warning"},
+		{"/pipe", http.StatusOK, 0, "", "Client connection lost"},
+		{"/outer", http.StatusInternalServerError, 1, "ERROR: Panic recovered", "middleware failed"},
+	}
+	for _, tt := range tests {
+		before := panicsCounted(t, tt.path)
+		out.Reset()
+		w := jsonRequest(r, "GET", tt.path, "")
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.path, w.Code, tt.want)
+		}
+		if got := panicsCounted(t, tt.path) - before; got != tt.counted {
+			t.Errorf("%s: %v panics counted, want %v", tt.path, got, tt.counted)
+		}
+		if !strings.Contains(out.String(), tt.logged) {
+			t.Errorf("%s: log lacks %q:\n%s", tt.path, tt.logged, out.String())
+		}
+
+		var alerts []string
+	collect:
+		for {
+			select {
+			case p := <-posts:
+				alerts = append(alerts, p["text"].(string))
+			case <-time.After(200 * time.Millisecond):
+				break collect
+			}
+		}
+		if tt.alert == "" && len(alerts) > 0 || tt.alert != "" && (len(alerts) != 1 || alerts[0] != tt.alert) {
+			t.Errorf("%s: alerts %q, want %q", tt.path, alerts, tt.alert)
+		}
+	}
+}
+
+func TestBrokenPipe(t *testing.T) {
+	if !brokenPipe(syscall.EPIPE) || !brokenPipe(syscall.ECONNRESET) {
+		t.Error("EPIPE and ECONNRESET are broken pipes")
+	}
+	if brokenPipe(errors.New("boom")) || brokenPipe("boom") {
+		t.Error("other panics are not broken pipes")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"contactmanager/RedisConnector"
+	"context"
+	"syscall"
+)
+
+// InitRedis connects to the Redis cluster when Redis.Enabled is set. A failed
+// connect is logged and the server starts without it, /readyz then reports
+// the instance as not ready. SIGUSR2 rebuilds the slot map after a reshard.
+// The returned function closes the connections.
+func InitRedis(c *appContext) func() {
+	conf := c.Config().Redis
+	if !conf.Enabled {
+		return func() {}
+	}
+
+	client, err := RedisConnector.New(context.Background(), RedisConnector.Options{
+		Host:     conf.Host,
+		Port:     conf.Port,
+		Password: conf.Password,
+		Log:      c.Log.Msg,
+	})
+	if err != nil {
+		c.Log.Msg(3, "Redis unavailable: "+err.Error())
+		return func() {}
+	}
+	client.ReloadOnSignal(syscall.SIGUSR2)
+
+	c.Redis = client
+	c.RedisPing = client.PingNodes
+	c.Log.Msg(1, "Connected to Redis cluster at [ "+conf.Host+":"+conf.Port+" ]")
+
+	return func() {
+		if err := client.Close(); err != nil {
+			c.Log.Msg(2, "Closing Redis failed: "+err.Error())
+		}
+	}
+}
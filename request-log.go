@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"regexp"
+)
+
+const (
+	// logContextKey holds the request's *LogEntry in the gin context
+	logContextKey = "log"
+
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "requestID"
+)
+
+// validRequestID limits what an upstream proxy may hand us, anything else is
+// replaced so a client cannot inject text into the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestID returns the ID RequestLogger gave the request
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// RequestLogger takes the X-Request-ID set by a proxy in front of us, or makes
+// one up, and echoes it in the response. It attaches a logger carrying the
+// request ID, trace ID, route and client IP to the context, later middleware
+// adds the user and address book through AddLogFields.
+func (ac *appContext) RequestLogger(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+
+	log := ac.Log.WithFields(logrus.Fields{
+		"request_id": id,
+		"method":     c.Request.Method,
+		"route":      c.FullPath(),
+		"client_ip":  c.ClientIP(),
+	})
+	if fields := traceFields(c.Request.Context()); fields != nil {
+		log = log.WithFields(fields)
+	}
+	c.Set(logContextKey, log)
+
+	c.Next()
+}
+
+// AddLogFields adds fields to the request logger for the rest of the request
+func (ac *appContext) AddLogFields(c *gin.Context, fields logrus.Fields) {
+	c.Set(logContextKey, ac.Log.WithContext(c).WithFields(fields))
+}
+
+// JSONError answers with an error body carrying the status' error code and
+// the request ID so a report can be matched to the log
+func (ac *appContext) JSONError(code int, message string, c *gin.Context) {
+	c.JSON(code, gin.H{
+		"error":      message,
+		"code":       statusError(code).Code,
+		"request_id": RequestID(c),
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestLoggerFields(t *testing.T) {
+	ac, out := newTestContext()
+	r := gin.New()
+	r.Use(ac.RequestLogger)
+	r.GET("/books/:id", func(c *gin.Context) {
+		ac.AddLogFields(c, logrus.Fields{"user": "alice"})
+		ac.Log.WithContext(c).WithFields(logrus.Fields{"book": c.Param("id")}).Msg(1, "book opened")
+	})
+
+	req := httptest.NewRequest("GET", "/books/b1", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	req.RemoteAddr = "192.0.2.7:4000"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	logged := out.String()
+	for _, field := range []string{"request_id=req-1", "method=GET", "route=\"/books/:id\"", "client_ip=192.0.2.7", "user=alice", "book=b1"} {
+		if !strings.Contains(logged, field) {
+			t.Errorf("%s missing from the request log:\n%s", field, logged)
+		}
+	}
+
+	// outside a request the plain logger is used
+	out.Reset()
+	ac.Log.WithContext(nil).Msg(1, "no request")
+	if strings.Contains(out.String(), "request_id") {
+		t.Errorf("a request ID outside a request:\n%s", out.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{16}$`)
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"req-1", true},
+		{"edge.proxy:42_a-B", true},
+		{strings.Repeat("a", 64), true},
+		{"", false},
+		{strings.Repeat("a", 65), false},
+		{"two words", false},
+		{"id\nlevel=error", false},
+		{"<script>", false},
+	}
+	for _, tt := range tests {
+		ac, out := newTestContext()
+		r := gin.New()
+		r.Use(ac.RequestLogger)
+		r.GET("/", func(c *gin.Context) {
+			ac.Log.WithContext(c).Msg(1, "handled")
+			ac.JSONError(400, "bad", c)
+		})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Set(requestIDHeader, tt.header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		id := w.Header().Get(requestIDHeader)
+		if tt.keep && id != tt.header {
+			t.Errorf("%q was replaced with %q", tt.header, id)
+		}
+		if !tt.keep && !generated.MatchString(id) {
+			t.Errorf("%q answered with %q, want a generated ID", tt.header, id)
+		}
+
+		var body struct {
+			RequestID string `json:"request_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if body.RequestID != id {
+			t.Errorf("error body carries %q, header %q", body.RequestID, id)
+		}
+		// logrus quotes values holding characters such as ':'
+		if !regexp.MustCompile(`request_id="?` + regexp.QuoteMeta(id) + `"? `).MatchString(out.String()) {
+			t.Errorf("%q not logged:\n%s", id, out.String())
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type Permission string
+
+const (
+	PermContactRead   Permission = "contacts:read"
+	PermContactWrite  Permission = "contacts:write"
+	PermContactDelete Permission = "contacts:delete"
+	PermContactImport Permission = "contacts:import"
+	PermAdmin         Permission = "admin"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:  {PermContactRead, PermContactWrite, PermContactDelete, PermContactImport, PermAdmin},
+	RoleEditor: {PermContactRead, PermContactWrite},
+	RoleViewer: {PermContactRead},
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func RoleHas(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission aborts with a 403 unless the logged in user's role grants
+// perm and, for API token requests, the token's scopes cover it.
+// Must be registered after RequireUser.
+func (ac *appContext) RequirePermission(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil || !RoleHas(user.Role, perm) || !TokenAllows(c, perm) {
+			ac.AbortError(ErrForbidden.Because("permission denied: "+string(perm)), c)
+			return
+		}
+		c.Next()
+	}
+}
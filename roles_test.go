@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+func TestRoleHas(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleAdmin, PermAdmin, true},
+		{RoleAdmin, PermContactDelete, true},
+		{RoleEditor, PermContactWrite, true},
+		{RoleEditor, PermContactDelete, false},
+		{RoleEditor, PermAdmin, false},
+		{RoleViewer, PermContactRead, true},
+		{RoleViewer, PermContactWrite, false},
+		{"owner", PermContactRead, false},
+	}
+	for _, tt := range tests {
+		if got := RoleHas(tt.role, tt.perm); got != tt.want {
+			t.Errorf("RoleHas(%q, %q) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	ac, _ := newTestContext()
+
+	tests := []struct {
+		name   string
+		user   *User
+		scopes []string
+		perm   Permission
+		want   int
+	}{
+		{"no user", nil, nil, PermContactRead, http.StatusForbidden},
+		{"viewer reads", &User{Role: RoleViewer}, nil, PermContactRead, http.StatusOK},
+		{"viewer writes", &User{Role: RoleViewer}, nil, PermContactWrite, http.StatusForbidden},
+		{"editor token with read scope writes", &User{Role: RoleEditor}, []string{ScopeContactsRead}, PermContactWrite, http.StatusForbidden},
+		{"editor token with write scope writes", &User{Role: RoleEditor}, []string{ScopeContactsWrite}, PermContactWrite, http.StatusOK},
+		{"admin token with admin scope", &User{Role: RoleAdmin}, []string{ScopeAdmin}, PermAdmin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := gin.New()
+		r.GET("/", func(c *gin.Context) {
+			if tt.user != nil {
+				c.Set("user", tt.user)
+			}
+			if tt.scopes != nil {
+				c.Set("tokenScopes", tt.scopes)
+			}
+		}, ac.RequirePermission(tt.perm), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		if w := jsonRequest(r, "GET", "/", ""); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const secretMask = "[REDACTED]"
+
+// secretSettings may hold "env:NAME" or "file:/path" references and are
+// masked wherever configuration or log output is shown
+var secretSettings = []string{
+	"APIkey",
+	"SlackHook",
+	"SMS.Secret",
+	"SQL.Password",
+	"Redis.Password",
+	"OIDC.ClientSecret",
+}
+
+func isSecret(path []string) bool {
+	joined := strings.Join(path, ".")
+	for _, s := range secretSettings {
+		if joined == s {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecret returns the value a secret reference points at, plain values
+// are returned unchanged
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", &os.PathError{Op: "lookup", Path: "$" + name, Err: os.ErrNotExist}
+		}
+		return v, nil
+	case strings.HasPrefix(value, "file:"):
+		b, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// resolveSecrets replaces every secret reference in c with its value
+func resolveSecrets(c *Params) ConfigErrors {
+	var errs ConfigErrors
+
+	walkParams(reflect.ValueOf(c).Elem(), nil, func(path []string, field reflect.Value) {
+		if !isSecret(path) {
+			return
+		}
+		v, err := resolveSecret(field.String())
+		if err != nil {
+			errs = append(errs, strings.Join(path, ".")+": "+err.Error())
+			return
+		}
+		field.SetString(v)
+	})
+
+	return errs
+}
+
+// Secrets returns the non empty secret values of c
+func (c Params) Secrets() []string {
+	var secrets []string
+
+	walkParams(reflect.ValueOf(&c).Elem(), nil, func(path []string, field reflect.Value) {
+		if isSecret(path) && field.String() != "" {
+			secrets = append(secrets, field.String())
+		}
+	})
+
+	return secrets
+}
+
+// Redacted returns a copy of c that is safe to print
+func (c Params) Redacted() Params {
+	walkParams(reflect.ValueOf(&c).Elem(), nil, func(path []string, field reflect.Value) {
+		if isSecret(path) && field.String() != "" {
+			field.SetString(secretMask)
+		}
+	})
+	return c
+}
+
+// Redactor masks known secret values in text. It doubles as a logrus hook so
+// nothing written through ErrorHandler can leak a secret.
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// SetSecrets replaces the values to mask, including their URL escaped forms
+func (r *Redactor) SetSecrets(secrets []string) {
+	var all []string
+	for _, s := range secrets {
+		if s == "" {
+			continue
+		}
+		all = append(all, s)
+		if e := url.QueryEscape(s); e != s {
+			all = append(all, e)
+		}
+		if e := url.PathEscape(s); e != s {
+			all = append(all, e)
+		}
+	}
+
+	r.mu.Lock()
+	r.secrets = all
+	r.mu.Unlock()
+}
+
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, secretMask, -1)
+	}
+	return s
+}
+
+func (r *Redactor) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (r *Redactor) Fire(entry *logrus.Entry) error {
+	entry.Message = r.Redact(entry.Message)
+	for k, v := range entry.Data {
+		if s, ok := v.(string); ok {
+			entry.Data[k] = r.Redact(s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db-password")
+	if err := os.WriteFile(path, []byte("from file\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CM_TEST_SECRET", "from env")
+
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"plain", "plain", false},
+		{"", "", false},
+		{"env:CM_TEST_SECRET", "from env", false},
+		{"env:CM_TEST_MISSING", "", true},
+		{"file:" + path, "from file", false},
+		{"file:" + filepath.Join(dir, "missing"), "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveSecret(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("resolveSecret(%q) = %q, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("CM_TEST_SECRET", "from env")
+
+	c := DefaultParams()
+	c.SQL.Password = "env:CM_TEST_SECRET"
+	c.Redis.Password = "env:CM_TEST_MISSING"
+	c.OIDC.ClientSecret = "file:" + filepath.Join(t.TempDir(), "missing")
+	// only secret settings are resolved
+	c.SQL.User = "env:CM_TEST_SECRET"
+
+	errs := resolveSecrets(&c)
+	if c.SQL.Password != "from env" {
+		t.Errorf("SQL.Password = %q", c.SQL.Password)
+	}
+	if c.SQL.User != "env:CM_TEST_SECRET" {
+		t.Errorf("SQL.User was resolved to %q", c.SQL.User)
+	}
+	if len(errs) != 2 || !strings.HasPrefix(errs[0], "Redis.Password: ") || !strings.HasPrefix(errs[1], "OIDC.ClientSecret: ") {
+		t.Errorf("errors %q, want one for Redis.Password and OIDC.ClientSecret", errs)
+	}
+
+	// LoadConfig reports them with the other configuration errors
+	_, err := LoadConfig("", []string{"-SQL.User=test", "-Redis.Password=env:CM_TEST_MISSING", "-ListenPort=99999"})
+	if errs, ok := err.(ConfigErrors); !ok || len(errs) != 2 {
+		t.Errorf("LoadConfig returned %v, want both errors", err)
+	}
+}
+
+func TestRedactorHook(t *testing.T) {
+	secret := "p@ss word/1"
+
+	var out bytes.Buffer
+	log := logrus.New()
+	log.Out = &out
+	redactor := &Redactor{}
+	redactor.SetSecrets([]string{secret, ""})
+	log.AddHook(redactor)
+
+	log.WithFields(logrus.Fields{
+		"dsn":   "postgres://app:" + url.QueryEscape(secret) + "@db/contacts",
+		"path":  "/x/" + url.PathEscape(secret),
+		"count": 3,
+	}).Warn("login with " + secret + " failed")
+
+	logged := out.String()
+	for _, leak := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret)} {
+		if strings.Contains(logged, leak) {
+			t.Errorf("%q was logged:\n%s", leak, logged)
+		}
+	}
+	if strings.Count(logged, secretMask) != 3 || !strings.Contains(logged, "count=3") {
+		t.Errorf("entry not masked as expected:\n%s", logged)
+	}
+
+	var empty *Redactor
+	if got := empty.Redact(secret); got != secret {
+		t.Errorf("nil Redactor changed %q to %q", secret, got)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := DefaultParams()
+	c.SQL.Password = "hunter2"
+	c.SlackHook = "https://hooks.example.com/T000/B000"
+
+	r := c.Redacted()
+	if r.SQL.Password != secretMask || r.SlackHook != secretMask {
+		t.Errorf("secrets not masked: %q, %q", r.SQL.Password, r.SlackHook)
+	}
+	if r.Redis.Password != "" {
+		t.Errorf("an empty secret shows as %q", r.Redis.Password)
+	}
+	if c.SQL.Password != "hunter2" {
+		t.Error("Redacted changed the original")
+	}
+
+	// config check prints the redacted configuration
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"SQL": {"User": "test", "Password": "hunter2"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var code int
+	printed := captureStdout(t, func() { code = cmdConfig(file, nil, []string{"check"}) })
+	if code != 0 || strings.Contains(printed, "hunter2") || !strings.Contains(printed, `"Password": "`+secretMask+`"`) {
+		t.Errorf("config check exit %d printed:\n%s", code, printed)
+	}
+}
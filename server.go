@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds how long in-flight requests get to finish once
+// SIGINT or SIGTERM arrives, and how long the trace flush may take
+const shutdownTimeout = 15 * time.Second
+
+// listenAndServe serves handler on addr until the server fails or SIGINT or
+// SIGTERM arrives. It returns after a graceful shutdown, so deferred clean up
+// in the caller, such as closing Redis and flushing traces, gets to run.
+func (ac *appContext) listenAndServe(addr string, handler http.Handler) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	return ac.serveUntil(&http.Server{Addr: addr, Handler: handler}, stop)
+}
+
+func (ac *appContext) serveUntil(srv *http.Server, stop <-chan os.Signal) error {
+	failed := make(chan error, 1)
+	go func() {
+		failed <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case sig := <-stop:
+		ac.Log.Msg(1, "Received "+sig.String()+", shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
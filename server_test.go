@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServeUntilFinishesInFlightRequests(t *testing.T) {
+	ac, _ := newTestContext()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})}
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- ac.serveUntil(srv, stop)
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		for {
+			resp, err := http.Get("http://" + addr + "/")
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			body <- string(b)
+			return
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server never answered")
+	}
+	stop <- syscall.SIGTERM
+
+	select {
+	case err := <-served:
+		t.Fatalf("returned before the in-flight request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if got := <-body; got != "done" {
+		t.Errorf("in-flight request got %q", got)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("shutdown failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveUntil did not return after SIGTERM")
+	}
+}
+
+func TestServeUntilListenFailure(t *testing.T) {
+	ac, _ := newTestContext()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the address is taken, serveUntil returns without a signal
+	srv := &http.Server{Addr: l.Addr().String(), Handler: http.NotFoundHandler()}
+	served := make(chan error, 1)
+	go func() {
+		served <- ac.serveUntil(srv, make(chan os.Signal))
+	}()
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Error("listening on a taken address returned nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveUntil did not return after failing to listen")
+	}
+}
@@ -1,20 +1,21 @@
 package main
 
 import (
-	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 	"net/http"
 )
 
 type formPostData struct {
-	ID          string `form:"contactID"sql:"id" json:"id"` //I don't think conactID is getting any values
-	FirstName   string `form:"firstName"sql:"first_name" json:"first_name"`
-	LastName    string `form:"lastName"sql:"last_name" json:"last_name"`
-	Phone       int    `form:"phone"sql:"phone" json:"phone"`
-	OfficePhone int    `form:"officePhone"sql:"office_phone" json:"office_phone"`
-	City        string `form:"city"sql:"city" json:"city"`
-	State       string `form:"state"sql:"state" json:"state"`
-	Zip         string `form:"zip"sql:"zip" json:"zip"`
+	ID          string `form:"contactID" sql:"id" json:"id"`
+	BookID      string `form:"bookID" sql:"address_book_id" json:"address_book_id"`
+	FirstName   string `form:"firstName" sql:"first_name" json:"first_name"`
+	LastName    string `form:"lastName" sql:"last_name" json:"last_name"`
+	Phone       int    `form:"phone" sql:"phone" json:"phone"`
+	OfficePhone int    `form:"officePhone" sql:"office_phone" json:"office_phone"`
+	City        string `form:"city" sql:"city" json:"city"`
+	State       string `form:"state" sql:"state" json:"state"`
+	Zip         string `form:"zip" sql:"zip" json:"zip"`
 }
 
 func NewFormPostData() formPostData {
@@ -39,7 +40,8 @@ func NewContact() ContactInfo {
 }
 
 func (ac *appContext) ShowIndex(c *gin.Context) {
-	ac.Log.Msg(1, "in context show index")
+	log := ac.Log.WithContext(c)
+	log.Msg(1, "in context show index")
 
 	query :=
 		` select 
@@ -47,34 +49,47 @@ func (ac *appContext) ShowIndex(c *gin.Context) {
 		from contacts 
 		where 
 			enabled
+			and address_book_id = $1
 			order by 3,2`
 
-	rows, err := ac.DB.Query(query)
+	book := CurrentBook(c)
+	rows, err := ac.DB.QueryContext(ac.QueryCtx(c, "contacts.list"), query, book.ID)
 	if check := ac.DBErrorCheck(err, query, c); check == false {
-		ac.Log.Msg(1, "db error")
+		log.Msg(1, "db error")
 		return
 	}
 	defer rows.Close()
 
 	var contacts []ContactInfo
 
-	ac.Log.Msg(1, "before assignment")
+	log.Msg(1, "before assignment")
 	for rows.Next() {
 		contact := NewContact()
 
 		err := rows.Scan(&contact.ID, &contact.FirstName, &contact.LastName, &contact.Phone, &contact.OfficePhone,
 			&contact.City, &contact.State, &contact.Zip, &contact.Enabled)
 		if err != nil {
-			ac.Log.Msg(3, fmt.Sprintf("Error scanning row: %s", err.Error()))
+			log.WithFields(logrus.Fields{"error": err.Error()}).Msg(3, "Error scanning row")
 		}
 
-		ac.Log.Msg(1, fmt.Sprintf("%#v", contact))
+		log.WithFields(logrus.Fields{"contact_id": contact.ID}).Msg(0, "Loaded contact")
 
 		contacts = append(contacts, contact)
 	}
 
+	user := CurrentUser(c)
+	books, err := ac.UserBooks(c, user.ID)
+	if err != nil {
+		log.WithFields(logrus.Fields{"error": err.Error()}).Msg(3, "Error loading address books")
+	}
+
 	c.HTML(http.StatusOK, "main/index", gin.H{
-		"contacts": contacts,
+		"contacts":  contacts,
+		"user":      user,
+		"book":      book,
+		"books":     books,
+		"canEdit":   RoleHas(user.Role, PermContactWrite) && bookPermissionRank[book.Permission] >= bookPermissionRank[BookWrite],
+		"canDelete": RoleHas(user.Role, PermContactDelete) && bookPermissionRank[book.Permission] >= bookPermissionRank[BookWrite],
 	})
 
 }
@@ -83,28 +98,30 @@ func (ac *appContext) uploadContact(c *gin.Context) {
 	var form formPostData
 
 	if err := c.Bind(&form); err != nil {
-		fmt.Println("bind error" + err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ac.Log.WithContext(c).WithFields(logrus.Fields{"error": err.Error()}).Msg(3, "bind error")
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
 		return
 	}
-	c.JSON(200, gin.H{"data": "ok"})
-	fmt.Printf("%+v", form)
 	query := "insert into contacts (first_name, last_name, phone, office_phone, " +
-		"city, state, zip) " +
-		"values ($1, $2, $3, $4, $5, $6, $7)"
-	_, err := ac.DB.Exec(query, &form.FirstName, &form.LastName, &form.Phone,
-		&form.OfficePhone, &form.City, &form.State, &form.Zip)
-	ac.DBErrorCheck(err, query, c)
+		"city, state, zip, address_book_id) " +
+		"values ($1, $2, $3, $4, $5, $6, $7, $8)"
+	_, err := ac.DB.ExecContext(ac.QueryCtx(c, "contacts.upload"), query, &form.FirstName, &form.LastName, &form.Phone,
+		&form.OfficePhone, &form.City, &form.State, &form.Zip, CurrentBook(c).ID)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	c.JSON(200, gin.H{"data": "ok"})
 
 }
 func (ac *appContext) editContact(c *gin.Context) {
 	form := NewFormPostData()
 
 	if err := c.Bind(&form); err != nil {
-		ac.Log.Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ac.Log.WithContext(c).WithFields(logrus.Fields{"error": err.Error()}).Msg(3, "bind error")
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
 		return
 	}
+	ac.AddLogFields(c, logrus.Fields{"contact_id": form.ID})
 	query := `
 		select 
 			id, first_name, last_name, phone, office_phone, city, 
@@ -112,11 +129,12 @@ state, zip
 		from contacts 
 		where
 			id = $1
+			and address_book_id = $2
 	`
-	row := ac.DB.QueryRow(query, &form.ID)
+	row := ac.DB.QueryRowContext(ac.QueryCtx(c, "contacts.get"), query, &form.ID, CurrentBook(c).ID)
 	err := row.Scan(&form.ID, &form.FirstName, &form.LastName, &form.Phone, &form.OfficePhone, &form.City, &form.State, &form.Zip)
 	if check := ac.DBErrorCheck(err, query, c); check == false {
-		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"ID":          form.ID,
@@ -133,21 +151,22 @@ func (ac *appContext) saveContact(c *gin.Context) {
 	form := NewFormPostData()
 
 	if err := c.Bind(&form); err != nil {
-		ac.Log.Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ac.Log.WithContext(c).WithFields(logrus.Fields{"error": err.Error()}).Msg(3, "bind error")
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
 		return
 	}
+	ac.AddLogFields(c, logrus.Fields{"contact_id": form.ID})
 	if form.ID == "" {
-		query := `insert into contacts (first_name, last_name, phone, office_phone, city, state, zip)
-values ('$1','$2','$3','$4','$5','$6','$7') returning id; `
-		row := ac.DB.QueryRow(query, &form.FirstName, &form.LastName, &form.Phone, &form.OfficePhone, &form.City, &form.State, &form.Zip)
+		query := `insert into contacts (first_name, last_name, phone, office_phone, city, state, zip, address_book_id)
+values ($1, $2, $3, $4, $5, $6, $7, $8) returning id; `
+		row := ac.DB.QueryRowContext(ac.QueryCtx(c, "contacts.insert"), query, &form.FirstName, &form.LastName, &form.Phone, &form.OfficePhone, &form.City, &form.State, &form.Zip, CurrentBook(c).ID)
 		err := row.Scan(&form.ID)
 		if check := ac.DBErrorCheck(err, query, c); check == false {
-			ac.AbortMsg(http.StatusInternalServerError, err, c)
+			return
 		}
 	} else {
 		query := `
-			update user_contacts set 
+			update contacts set 
 				first_name = $1,
 				last_name = $2,
 				phone = $3,
@@ -158,19 +177,15 @@ values ('$1','$2','$3','$4','$5','$6','$7') returning id; `
 
 			where
 				id = $8
+				and address_book_id = $9
 		`
-		res, err := ac.DB.Exec(query, &form.FirstName, &form.LastName, &form.Phone, &form.OfficePhone, &form.City, &form.State,  &form.Zip)
+		res, err := ac.DB.ExecContext(ac.QueryCtx(c, "contacts.update"), query, &form.FirstName, &form.LastName, &form.Phone, &form.OfficePhone, &form.City, &form.State, &form.Zip, &form.ID, CurrentBook(c).ID)
 		if check := ac.DBErrorCheck(err, query, c); check == false {
-			ac.AbortMsg(http.StatusInternalServerError, err, c)
 			return
 		}
 		ra, _ := res.RowsAffected()
 
-		ac.Log.Msg(0, fmt.Sprintf("rows affected [ %d ]", ra))
-
-
-
-
+		ac.Log.WithContext(c).WithFields(logrus.Fields{"rows_affected": ra}).Msg(0, "Contact updated")
 	}
 
 }
@@ -178,22 +193,23 @@ func (ac *appContext) deleteContact(c *gin.Context) {
 	form := NewFormPostData()
 
 	if err := c.Bind(&form); err != nil {
-		ac.Log.Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ac.Log.WithContext(c).WithFields(logrus.Fields{"error": err.Error()}).Msg(3, "bind error")
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
 		return
 	}
+	ac.AddLogFields(c, logrus.Fields{"contact_id": form.ID})
 	query := `
 		delete from contacts
 		where
 			id = $1
+			and address_book_id = $2
 `
 
-	res, err := ac.DB.Exec(query, &form.ID)
+	res, err := ac.DB.ExecContext(ac.QueryCtx(c, "contacts.delete"), query, &form.ID, CurrentBook(c).ID)
 	if check := ac.DBErrorCheck(err, query, c); check == false {
-		ac.AbortMsg(http.StatusInternalServerError, err, c)
 		return
 	}
 	ra, err := res.RowsAffected()
-	ac.Log.Msg(0, fmt.Sprintf("rows affected [ %d ]", ra))
+	ac.Log.WithContext(c).WithFields(logrus.Fields{"rows_affected": ra}).Msg(0, "Contact deleted")
 
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// a failed query must answer with exactly one error body, jQuery rejects
+// two JSON documents in a row
+func TestFailedQueryAnswersOnce(t *testing.T) {
+	ac, _ := newTestContext()
+	ac.DB = (&fakeDB{respond: func(string, []driver.Value) fakeResult {
+		return fakeResult{err: errors.New("connection reset by peer")}
+	}}).open()
+
+	r := gin.New()
+	withBook := func(c *gin.Context) {
+		c.Set("user", &User{ID: "u1", Username: "alice", Role: RoleEditor})
+		c.Set("book", &AddressBook{ID: "b1", Permission: BookWrite})
+	}
+	r.POST("/formData", withBook, ac.uploadContact)
+	r.POST("/editContact", withBook, ac.editContact)
+	r.POST("/saveUpdate", withBook, ac.saveContact)
+	r.POST("/deleteContact", withBook, ac.deleteContact)
+	r.GET("/logout", ac.logout)
+
+	requests := []struct{ method, path, body string }{
+		{"POST", "/formData", "firstName=Ada"},
+		{"POST", "/editContact", "contactID=c1"},
+		{"POST", "/saveUpdate", "firstName=Ada"},
+		{"POST", "/saveUpdate", "contactID=c1&firstName=Ada"},
+		{"POST", "/deleteContact", "contactID=c1"},
+	}
+	for _, req := range requests {
+		w := jsonRequest(r, req.method, req.path, req.body)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s %s: status %d, want 500", req.path, req.body, w.Code)
+		}
+		dec := json.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+		var first map[string]interface{}
+		if err := dec.Decode(&first); err != nil {
+			t.Errorf("%s %s: body is not JSON: %q", req.path, req.body, w.Body.String())
+			continue
+		}
+		if err := dec.Decode(&first); err != io.EOF {
+			t.Errorf("%s %s: more than one JSON body: %q", req.path, req.body, w.Body.String())
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/logout", nil)
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "tok"})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Location") != "" {
+		t.Errorf("logout: status %d, location %q, want a plain 500", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	slackQueueSize = 100
+	slackTimeout   = 5 * time.Second
+	// Slack rejects section text over 3000 characters
+	slackTextLimit = 2900
+)
+
+// slackAlert is one error waiting to be posted
+type slackAlert struct {
+	Signature string
+	Level     string
+	Message   string
+	Fields    map[string]string
+	Stack     string
+	Time      time.Time
+	Repeated  int // alerts with this signature suppressed since the last post
+}
+
+// SlackNotifier posts alerts to the SlackHook webhook from a bounded queue.
+// Alerts sharing a signature (level, message and error) are posted at most
+// once per SlackAlertIntervalSeconds, the next post reports how many were
+// held back. When the queue is full new alerts are dropped, alerting must
+// never slow down or block a request.
+type SlackNotifier struct {
+	mu         sync.Mutex
+	hook       string
+	channel    string
+	interval   time.Duration
+	lastSent   map[string]time.Time
+	suppressed map[string]int
+
+	queue   chan slackAlert
+	start   sync.Once
+	client  *http.Client
+	OnError func(err error) // delivery failures, must not log at error level
+}
+
+func NewSlackNotifier() *SlackNotifier {
+	return &SlackNotifier{
+		lastSent:   make(map[string]time.Time),
+		suppressed: make(map[string]int),
+		queue:      make(chan slackAlert, slackQueueSize),
+		client:     &http.Client{Timeout: slackTimeout},
+	}
+}
+
+// Configure applies the Slack settings, safe to call on a live notifier
+func (s *SlackNotifier) Configure(hook string, channel string, interval time.Duration) {
+	s.mu.Lock()
+	s.hook, s.channel, s.interval = hook, channel, interval
+	s.mu.Unlock()
+}
+
+// admit applies the per signature rate limit, before the alert is built so a
+// flood of one error costs no stack walks or redaction. It returns false when
+// the alert is held back, otherwise how many were suppressed before it.
+func (s *SlackNotifier) admit(signature string, now time.Time) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hook == "" {
+		return 0, false
+	}
+	if last, ok := s.lastSent[signature]; ok && now.Sub(last) < s.interval {
+		s.suppressed[signature]++
+		return 0, false
+	}
+
+	// forget signatures that are quiet again so the maps stay small
+	if len(s.lastSent) > 1000 {
+		for sig, last := range s.lastSent {
+			if now.Sub(last) >= s.interval {
+				delete(s.lastSent, sig)
+				delete(s.suppressed, sig)
+			}
+		}
+	}
+
+	s.lastSent[signature] = now
+	repeated := s.suppressed[signature]
+	delete(s.suppressed, signature)
+	return repeated, true
+}
+
+// Alert queues an admitted alert for delivery, or drops it when the queue is
+// full
+func (s *SlackNotifier) Alert(a slackAlert) {
+	s.start.Do(func() {
+		go func() {
+			for a := range s.queue {
+				s.report(s.post(a))
+			}
+		}()
+	})
+
+	select {
+	case s.queue <- a:
+	default:
+		s.report(errors.New("slack alert queue full, dropped: " + a.Message))
+	}
+}
+
+// AlertNow posts an admitted alert straight away, for fatal errors where the
+// process exits before the queue would drain
+func (s *SlackNotifier) AlertNow(a slackAlert) {
+	s.report(s.post(a))
+}
+
+func (s *SlackNotifier) report(err error) {
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+func (s *SlackNotifier) post(a slackAlert) error {
+	s.mu.Lock()
+	hook, channel := s.hook, s.channel
+	s.mu.Unlock()
+	if hook == "" {
+		return nil
+	}
+
+	payload := slackPayload(a)
+	if channel != "" {
+		payload["channel"] = channel
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(hook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.New("slack alert failed: " + err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("slack alert failed: " + resp.Status)
+	}
+	return nil
+}
+
+func slackText(kind string, text string) map[string]interface{} {
+	text = truncate(text, slackTextLimit)
+	return map[string]interface{}{"type": kind, "text": text}
+}
+
+// slackPayload formats a as Slack blocks: a header, the request fields, the
+// error, the stack and a footer with host and time
+func slackPayload(a slackAlert) map[string]interface{} {
+	host, _ := os.Hostname()
+
+	title := strings.ToUpper(a.Level) + ": " + a.Message
+	blocks := []interface{}{
+		map[string]interface{}{"type": "header", "text": slackText("plain_text", truncate(title, 150))},
+	}
+
+	// request_id goes first, it is what the on call engineer searches for
+	var names []string
+	for name := range a.Fields {
+		if name != "error" && name != "request_id" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := a.Fields["request_id"]; ok {
+		names = append([]string{"request_id"}, names...)
+	}
+
+	var fields []interface{}
+	for _, name := range names {
+		// a section holds at most 10 fields
+		if len(fields) == 10 {
+			break
+		}
+		fields = append(fields, slackText("mrkdwn", "*"+name+"*\n"+a.Fields[name]))
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+
+	if e := a.Fields["error"]; e != "" {
+		blocks = append(blocks, map[string]interface{}{"type": "section", "text": slackText("mrkdwn", "```"+e+"```")})
+	}
+	if a.Stack != "" {
+		blocks = append(blocks, map[string]interface{}{"type": "section", "text": slackText("mrkdwn", "*Stack*\n```"+a.Stack+"```")})
+	}
+
+	footer := host + " · " + a.Time.Format(time.RFC3339)
+	if a.Repeated > 0 {
+		footer += " · repeated " + strconv.Itoa(a.Repeated) + " more times since the last alert"
+	}
+	blocks = append(blocks, map[string]interface{}{
+		"type":     "context",
+		"elements": []interface{}{slackText("mrkdwn", footer)},
+	})
+
+	return map[string]interface{}{
+		"text":   title, // shown in notifications
+		"blocks": blocks,
+	}
+}
+
+// truncate shortens s to at most n characters, Slack counts characters and a
+// cut inside a multibyte rune would make the payload invalid UTF-8
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// newSlackAlert builds an alert for a message logged through entry, false
+// when the rate limit holds it back. Values are redacted here, the logrus hook
+// only covers what is written to the log.
+func (e *ErrorHandler) newSlackAlert(entry *logrus.Entry, levelNum int, message string) (slackAlert, bool) {
+	level := "error"
+	switch levelNum {
+	case 4:
+		level = "fatal"
+	case 5:
+		level = "panic"
+	}
+
+	var cause string
+	if err, ok := entry.Data["error"]; ok {
+		cause = fmt.Sprint(err)
+	}
+	sum := sha1.Sum([]byte(level + "\x00" + message + "\x00" + cause))
+	signature := hex.EncodeToString(sum[:])
+
+	now := time.Now()
+	repeated, ok := e.Slack.admit(signature, now)
+	if !ok {
+		return slackAlert{}, false
+	}
+
+	fields := make(map[string]string, len(entry.Data))
+	for k, v := range entry.Data {
+		fields[k] = e.Redactor.Redact(fmt.Sprint(v))
+	}
+
+	// a recovered panic brings the stack of the panicking goroutine
+	stack, ok := fields["stack"]
+	if ok {
+		delete(fields, "stack")
+	} else {
+		stack = stackTrace(6)
+	}
+
+	return slackAlert{
+		Signature: signature,
+		Level:     level,
+		Message:   e.Redactor.Redact(message),
+		Fields:    fields,
+		Stack:     stack,
+		Time:      now,
+		Repeated:  repeated,
+	}, true
+}
+
+// stackTrace lists the callers above skip frames, one "function file:line"
+// per line, without the logging frames debug.Stack would add
+func stackTrace(skip int) string {
+	pc := make([]uintptr, 32)
+	n := runtime.Callers(skip, pc)
+	frames := runtime.CallersFrames(pc[:n])
+
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// slackWebhook records the payloads posted to it
+func slackWebhook(t *testing.T) (*httptest.Server, chan map[string]interface{}) {
+	posts := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("payload is not JSON: %v", err)
+		}
+		posts <- payload
+	}))
+	t.Cleanup(srv.Close)
+	return srv, posts
+}
+
+func nextPost(t *testing.T, posts chan map[string]interface{}) map[string]interface{} {
+	select {
+	case p := <-posts:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert was posted")
+	}
+	return nil
+}
+
+func TestSlackAlertWebhook(t *testing.T) {
+	srv, posts := slackWebhook(t)
+	ac, _ := newTestContext()
+	ac.Log.Slack.Configure(srv.URL, "#alerts", time.Hour)
+	ac.Log.Redactor.SetSecrets([]string{"hunter2"})
+
+	failed := ac.Log.WithFields(logrus.Fields{"error": "auth failed for hunter2", "request_id": "req-1"})
+	failed.Msg(3, "DB Query failed")
+	failed.Msg(3, "DB Query failed")
+	ac.Log.Msg(2, "warnings are not alerted")
+
+	payload := nextPost(t, posts)
+	raw, _ := json.Marshal(payload)
+	if payload["channel"] != "#alerts" || payload["text"] != "ERROR: DB Query failed" {
+		t.Errorf("channel %v, text %v", payload["channel"], payload["text"])
+	}
+	if strings.Contains(string(raw), "hunter2") {
+		t.Error("the secret reached Slack")
+	}
+	if !strings.Contains(string(raw), "req-1") {
+		t.Error("the request ID is missing")
+	}
+
+	select {
+	case p := <-posts:
+		t.Errorf("rate limited alert was posted: %v", p["text"])
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSlackAlertReportsSuppressed(t *testing.T) {
+	srv, posts := slackWebhook(t)
+	ac, _ := newTestContext()
+	ac.Log.Slack.Configure(srv.URL, "", 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		ac.Log.Msg(3, "Redis unavailable")
+	}
+	nextPost(t, posts)
+	time.Sleep(60 * time.Millisecond)
+	ac.Log.Msg(3, "Redis unavailable")
+
+	raw, _ := json.Marshal(nextPost(t, posts))
+	if !strings.Contains(string(raw), "repeated 2 more times") {
+		t.Errorf("suppressed alerts not reported: %s", raw)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	long := strings.Repeat("é", 200)
+	got := truncate(long, 150)
+	if !utf8.ValidString(got) {
+		t.Fatal("truncate split a rune")
+	}
+	if n := utf8.RuneCountInString(got); n != 150 {
+		t.Errorf("%d characters, want 150", n)
+	}
+	if truncate("short", 150) != "short" {
+		t.Error("a short string was changed")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strconv"
+)
+
+// tracer names the spans this package starts, RedisConnector and
+// GinHTMLRender use their own
+var tracer = otel.Tracer("contactmanager")
+
+// InitTracing installs the global tracer provider for the Tracing settings.
+// With no exporter configured the default no-op provider stays in place and
+// spans cost next to nothing. The returned function flushes pending spans.
+func InitTracing(c *appContext) func() {
+	conf := c.Config().Tracing
+	if conf.Exporter == "" {
+		return func() {}
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch conf.Exporter {
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(conf.Endpoint)}
+		if conf.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	}
+	if err != nil {
+		c.Log.Msg(3, "Tracing disabled, exporter failed: "+err.Error())
+		return func() {}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", conf.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if conf.Exporter == "otlp" {
+		c.Log.Msg(1, "Tracing to [ "+conf.Endpoint+" ]")
+	} else {
+		c.Log.Msg(1, "Tracing to stdout")
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			c.Log.Msg(2, "Flushing traces failed: "+err.Error())
+		}
+	}
+}
+
+// tracedWriter hands the request context to GinHTMLRender so template spans
+// join the request's trace
+type tracedWriter struct {
+	gin.ResponseWriter
+	ctx context.Context
+}
+
+func (w *tracedWriter) Context() context.Context {
+	return w.ctx
+}
+
+// Tracing starts a server span per request, continuing the caller's trace
+// when it sent a traceparent header. Registered right after the outer
+// Recovery so every later middleware and query runs inside the span.
+func (ac *appContext) Tracing(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", c.Request.URL.Path),
+			attribute.String("client.address", c.ClientIP()),
+		),
+	)
+	defer span.End()
+
+	c.Request = c.Request.WithContext(ctx)
+	c.Writer = &tracedWriter{ResponseWriter: c.Writer, ctx: ctx}
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, strconv.Itoa(status))
+	}
+}
+
+// traceFields returns the trace and span ID of the span in ctx for log lines,
+// nil when ctx is not being traced
+func traceFields(ctx context.Context) logrus.Fields {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return logrus.Fields{
+		"trace_id": sc.TraceID().String(),
+		"span_id":  sc.SpanID().String(),
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+	"html/template"
+	"image/png"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	mfaVerify = "verify"
+	mfaEnrol  = "enrol"
+
+	totpIssuer        = "Contact Manager"
+	totpPeriod        = 30
+	recoveryCodeCount = 10
+
+	// wrong codes a session may send before it is dropped, and a user
+	// before their second factor is locked for twoFactorLockout
+	sessionFailureLimit = 5
+	userFailureLimit    = 10
+	twoFactorLockout    = 15 * time.Minute
+)
+
+type twoFactorPostData struct {
+	Code string `form:"code" binding:"required"`
+}
+
+type twoFactorSettingPostData struct {
+	Require string `form:"require"`
+}
+
+// twoFactorRequired reports whether the require_2fa setting covers role
+func twoFactorRequired(setting string, role string) bool {
+	if setting == "all" {
+		return true
+	}
+	for _, r := range strings.Split(setting, ",") {
+		if strings.TrimSpace(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (ac *appContext) twoFactorSetting(c *gin.Context) (string, error) {
+	var value string
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "settings.require_2fa"), `select value from settings where key = 'require_2fa'`).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// pendingSecondFactor decides what a fresh password login still owes:
+// mfaVerify for enrolled users, mfaEnrol when policy requires 2FA the user
+// has not set up, or nothing.
+func (ac *appContext) pendingSecondFactor(c *gin.Context, userID string) (string, error) {
+	var role string
+	var enabled bool
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "users.second_factor"), `select role, totp_enabled from users where id = $1`, userID).Scan(&role, &enabled)
+	if err != nil {
+		return "", err
+	}
+	if enabled {
+		return mfaVerify, nil
+	}
+
+	setting, err := ac.twoFactorSetting(c)
+	if err != nil {
+		return "", err
+	}
+	if twoFactorRequired(setting, role) {
+		return mfaEnrol, nil
+	}
+	return "", nil
+}
+
+// completeSecondFactor upgrades the current session to a full session
+func (ac *appContext) completeSecondFactor(c *gin.Context) bool {
+	token, _ := c.Cookie(sessionCookie)
+	query := `update sessions set mfa_pending = '' where token = $1`
+	_, err := ac.DB.ExecContext(ac.QueryCtx(c, "sessions.complete_2fa"), query, token)
+	return ac.DBErrorCheck(err, query, c)
+}
+
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.Replace(code, "-", "", -1)
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
+
+// isRecoveryCode reports whether code has the shape of a recovery code, only
+// those are checked against the bcrypt hashes
+func isRecoveryCode(code string) bool {
+	code = normalizeRecoveryCode(code)
+	if len(code) != 11 || code[5] != '-' {
+		return false
+	}
+	return strings.Trim(code[:5]+code[6:], "abcdefghijklmnopqrstuvwxyz234567") == ""
+}
+
+// totpStep returns the time step code was generated for, allowing one step of
+// clock skew either way as totp.Validate does. Steps up to lastStep were
+// already used and are refused.
+func totpStep(code string, secret string, lastStep int64, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - 1; step <= current+1; step++ {
+		if step <= lastStep {
+			continue
+		}
+		want, err := totp.GenerateCode(secret, time.Unix(step*totpPeriod, 0))
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// useTOTPStep records step as the user's last accepted step, false when a
+// concurrent request used it first
+func (ac *appContext) useTOTPStep(c *gin.Context, userID string, step int64) (bool, error) {
+	res, err := ac.DB.ExecContext(ac.QueryCtx(c, "users.totp_step"), `update users set totp_last_step = $2 where id = $1 and totp_last_step < $2`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// twoFactorFailed counts a wrong code against the session and the user. It
+// returns true when a limit was reached: the session is then deleted, and at
+// the user limit their second factor is locked for twoFactorLockout.
+func (ac *appContext) twoFactorFailed(c *gin.Context, user *User) (bool, error) {
+	token, _ := c.Cookie(sessionCookie)
+	var sessionFailures int
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "sessions.2fa_failed"), `update sessions set mfa_failures = mfa_failures + 1 where token = $1 returning mfa_failures`, token).Scan(&sessionFailures)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+		update users set
+			mfa_failures = case when mfa_failures + 1 >= $2 then 0 else mfa_failures + 1 end,
+			mfa_locked_until = case when mfa_failures + 1 >= $2 then now() + make_interval(secs => $3) else mfa_locked_until end
+		where
+			id = $1
+		returning
+			coalesce(mfa_locked_until > now(), false)`
+	var locked bool
+	err = ac.DB.QueryRowContext(ac.QueryCtx(c, "users.2fa_failed"), query, user.ID, userFailureLimit, twoFactorLockout.Seconds()).Scan(&locked)
+	if err != nil {
+		return false, err
+	}
+	if locked {
+		ac.Log.WithContext(c).Msg(3, "2FA locked for [ "+user.Username+" ] after repeated invalid codes")
+	}
+	if !locked && sessionFailures < sessionFailureLimit {
+		return false, nil
+	}
+
+	_, err = ac.DB.ExecContext(ac.QueryCtx(c, "sessions.delete"), `delete from sessions where token = $1`, token)
+	return true, err
+}
+
+// useRecoveryCode marks the first unused recovery code matching code as used
+func (ac *appContext) useRecoveryCode(c *gin.Context, userID string, code string) (bool, error) {
+	rows, err := ac.DB.QueryContext(ac.QueryCtx(c, "recovery_codes.list"), `select id, code_hash from recovery_codes where user_id = $1 and used is null`, userID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	code = normalizeRecoveryCode(code)
+	for rows.Next() {
+		var id int
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return false, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			_, err = ac.DB.ExecContext(ac.QueryCtx(c, "recovery_codes.use"), `update recovery_codes set used = now() where id = $1`, id)
+			return err == nil, err
+		}
+	}
+	return false, rows.Err()
+}
+
+// resetRecoveryCodes replaces the user's recovery codes and returns the new
+// codes in plain text, they are only shown once
+func (ac *appContext) resetRecoveryCodes(c *gin.Context, userID string) ([]string, error) {
+	tx, err := ac.DB.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ac.QueryCtx(c, "recovery_codes.clear"), `delete from recovery_codes where user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := HashPassword(code)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ac.QueryCtx(c, "recovery_codes.insert"), `insert into recovery_codes (user_id, code_hash) values ($1, $2)`, userID, hash); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, tx.Commit()
+}
+
+func (ac *appContext) ShowTwoFactor(c *gin.Context) {
+	if c.GetString("mfaPending") != mfaVerify {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+	c.HTML(http.StatusOK, "main/twofactor", gin.H{})
+}
+
+// verifyTwoFactor accepts either a current TOTP code not used before or an
+// unused recovery code. Wrong codes count towards sessionFailureLimit and
+// userFailureLimit.
+func (ac *appContext) verifyTwoFactor(c *gin.Context) {
+	var form twoFactorPostData
+
+	if c.GetString("mfaPending") != mfaVerify {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+	if err := c.ShouldBind(&form); err != nil {
+		c.HTML(http.StatusBadRequest, "main/twofactor", gin.H{"error": "Enter a code"})
+		return
+	}
+
+	user := CurrentUser(c)
+	var secret string
+	var lastStep int64
+	var locked bool
+	query := `select totp_secret, totp_last_step, coalesce(mfa_locked_until > now(), false) from users where id = $1`
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "users.totp_secret"), query, user.ID).Scan(&secret, &lastStep, &locked)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	if locked {
+		c.HTML(http.StatusTooManyRequests, "main/twofactor", gin.H{"error": "Too many invalid codes, try again later"})
+		return
+	}
+
+	step, ok := totpStep(strings.TrimSpace(form.Code), secret, lastStep, time.Now())
+	if ok {
+		ok, err = ac.useTOTPStep(c, user.ID, step)
+	} else if isRecoveryCode(form.Code) {
+		ok, err = ac.useRecoveryCode(c, user.ID, form.Code)
+		if ok {
+			ac.Log.WithContext(c).Msg(2, "Recovery code used by [ "+user.Username+" ]")
+		}
+	}
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	if !ok {
+		ac.Log.WithContext(c).Msg(2, "Failed 2FA for [ "+user.Username+" ]")
+		ended, err := ac.twoFactorFailed(c, user)
+		if err != nil {
+			ac.AbortMsg(http.StatusInternalServerError, err, c)
+			return
+		}
+		if ended {
+			ac.setCookie(c, sessionCookie, "", -1, "/")
+			c.HTML(http.StatusUnauthorized, "main/login", gin.H{"error": "Too many invalid codes, please log in again", "sso": ac.OIDC != nil})
+			return
+		}
+		c.HTML(http.StatusUnauthorized, "main/twofactor", gin.H{"error": "Invalid code"})
+		return
+	}
+
+	query = `update users set mfa_failures = 0, mfa_locked_until = null where id = $1`
+	_, err = ac.DB.ExecContext(ac.QueryCtx(c, "users.2fa_passed"), query, user.ID)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	if ac.completeSecondFactor(c) {
+		c.Redirect(http.StatusFound, "/")
+	}
+}
+
+// ShowEnrolTwoFactor generates a fresh pending secret and shows it as a QR code
+func (ac *appContext) ShowEnrolTwoFactor(c *gin.Context) {
+	if c.GetString("mfaPending") == mfaVerify {
+		c.Redirect(http.StatusFound, "/login/2fa")
+		return
+	}
+
+	user := CurrentUser(c)
+	var enabled bool
+	query := `select totp_enabled from users where id = $1`
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "users.totp_enabled"), query, user.ID).Scan(&enabled)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	if enabled {
+		c.HTML(http.StatusOK, "main/twofactor_enrol", gin.H{"enabled": true})
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Username})
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	img, err := key.Image(200, 200)
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+
+	query = `update users set totp_pending_secret = $1 where id = $2`
+	_, err = ac.DB.ExecContext(ac.QueryCtx(c, "users.totp_enrol"), query, key.Secret(), user.ID)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+
+	c.HTML(http.StatusOK, "main/twofactor_enrol", gin.H{
+		"required": c.GetString("mfaPending") == mfaEnrol,
+		"secret":   key.Secret(),
+		"qr":       template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())),
+	})
+}
+
+// enrolTwoFactor confirms the pending secret with a code from the
+// authenticator app, enables 2FA and shows the recovery codes
+func (ac *appContext) enrolTwoFactor(c *gin.Context) {
+	var form twoFactorPostData
+
+	if c.GetString("mfaPending") == mfaVerify {
+		c.Redirect(http.StatusFound, "/login/2fa")
+		return
+	}
+	if err := c.ShouldBind(&form); err != nil {
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
+		return
+	}
+
+	user := CurrentUser(c)
+	var secret sql.NullString
+	query := `select totp_pending_secret from users where id = $1`
+	err := ac.DB.QueryRowContext(ac.QueryCtx(c, "users.totp_secret"), query, user.ID).Scan(&secret)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	var step int64
+	ok := false
+	if secret.Valid {
+		step, ok = totpStep(strings.TrimSpace(form.Code), secret.String, 0, time.Now())
+	}
+	if !ok {
+		c.HTML(http.StatusUnauthorized, "main/twofactor_enrol", gin.H{"error": "Invalid code, scan the QR code again"})
+		return
+	}
+
+	query = `
+		update users set
+			totp_secret = totp_pending_secret,
+			totp_pending_secret = null,
+			totp_enabled = true,
+			totp_last_step = $2
+		where
+			id = $1`
+	_, err = ac.DB.ExecContext(ac.QueryCtx(c, "users.totp_enable"), query, user.ID, step)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+
+	codes, err := ac.resetRecoveryCodes(c, user.ID)
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	if !ac.completeSecondFactor(c) {
+		return
+	}
+
+	ac.Log.WithContext(c).Msg(1, "2FA enabled for [ "+user.Username+" ]")
+	c.HTML(http.StatusOK, "main/twofactor_enrol", gin.H{
+		"enabled":       true,
+		"recoveryCodes": codes,
+	})
+}
+
+// disableTwoFactor turns 2FA off when policy allows it. A current code not
+// used before is required so a hijacked session cannot strip the second
+// factor, wrong codes count towards the same limits as at login.
+func (ac *appContext) disableTwoFactor(c *gin.Context) {
+	var form twoFactorPostData
+
+	if err := c.ShouldBind(&form); err != nil {
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
+		return
+	}
+
+	user := CurrentUser(c)
+	setting, err := ac.twoFactorSetting(c)
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	if twoFactorRequired(setting, user.Role) {
+		ac.AbortError(ErrTwoFactorRequired.Because("2FA is required for role "+user.Role), c)
+		return
+	}
+
+	var secret sql.NullString
+	var lastStep int64
+	var locked bool
+	query := `select totp_secret, totp_last_step, coalesce(mfa_locked_until > now(), false) from users where id = $1`
+	err = ac.DB.QueryRowContext(ac.QueryCtx(c, "users.totp_secret"), query, user.ID).Scan(&secret, &lastStep, &locked)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	if locked {
+		ac.JSONError(http.StatusTooManyRequests, "too many invalid codes, try again later", c)
+		return
+	}
+
+	ok := false
+	if secret.Valid {
+		var step int64
+		if step, ok = totpStep(strings.TrimSpace(form.Code), secret.String, lastStep, time.Now()); ok {
+			if ok, err = ac.useTOTPStep(c, user.ID, step); err != nil {
+				ac.AbortMsg(http.StatusInternalServerError, err, c)
+				return
+			}
+		}
+	}
+	if !ok {
+		ac.Log.WithContext(c).Msg(2, "Failed 2FA disable for [ "+user.Username+" ]")
+		ended, err := ac.twoFactorFailed(c, user)
+		if err != nil {
+			ac.AbortMsg(http.StatusInternalServerError, err, c)
+			return
+		}
+		if ended {
+			ac.setCookie(c, sessionCookie, "", -1, "/")
+			ac.JSONError(http.StatusUnauthorized, "too many invalid codes, please log in again", c)
+			return
+		}
+		ac.JSONError(http.StatusUnauthorized, "invalid code", c)
+		return
+	}
+
+	query = `update users set totp_secret = null, totp_enabled = false, mfa_failures = 0, mfa_locked_until = null where id = $1`
+	_, err = ac.DB.ExecContext(ac.QueryCtx(c, "users.totp_disable"), query, user.ID)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+	_, err = ac.DB.ExecContext(ac.QueryCtx(c, "recovery_codes.clear"), `delete from recovery_codes where user_id = $1`, user.ID)
+	if check := ac.DBErrorCheck(err, "delete recovery codes", c); check == false {
+		return
+	}
+
+	ac.Log.WithContext(c).Msg(1, "2FA disabled for [ "+user.Username+" ]")
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+func (ac *appContext) getTwoFactorSetting(c *gin.Context) {
+	setting, err := ac.twoFactorSetting(c)
+	if err != nil {
+		ac.AbortMsg(http.StatusInternalServerError, err, c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"require": setting})
+}
+
+// setTwoFactorSetting stores who must use 2FA: "" for nobody, "all", or a
+// comma separated list of roles
+func (ac *appContext) setTwoFactorSetting(c *gin.Context) {
+	var form twoFactorSettingPostData
+
+	if err := c.ShouldBind(&form); err != nil {
+		ac.Log.WithContext(c).Msg(3, fmt.Sprintf("bind error: %s", err.Error()))
+		ac.JSONError(http.StatusBadRequest, err.Error(), c)
+		return
+	}
+
+	form.Require = strings.TrimSpace(form.Require)
+	if form.Require != "" && form.Require != "all" {
+		roles := strings.Split(form.Require, ",")
+		for i, r := range roles {
+			roles[i] = strings.TrimSpace(r)
+			if !ValidRole(roles[i]) {
+				ac.JSONError(http.StatusBadRequest, "unknown role "+roles[i], c)
+				return
+			}
+		}
+		form.Require = strings.Join(roles, ",")
+	}
+
+	query := `
+		insert into settings (key, value) values ('require_2fa', $1)
+		on conflict (key) do update set value = excluded.value`
+	_, err := ac.DB.ExecContext(ac.QueryCtx(c, "settings.set_require_2fa"), query, form.Require)
+	if check := ac.DBErrorCheck(err, query, c); check == false {
+		return
+	}
+
+	ac.Log.WithContext(c).Msg(1, "2FA requirement set to [ "+form.Require+" ] by [ "+CurrentUser(c).Username+" ]")
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func TestTOTPStep(t *testing.T) {
+	now := time.Now()
+	current := now.Unix() / totpPeriod
+	code, err := totp.GenerateCode(testTOTPSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, _ := totp.GenerateCode(testTOTPSecret, now.Add(-2*totpPeriod*time.Second))
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		ok       bool
+	}{
+		{"current code", code, 0, true},
+		{"code after an older one", code, current - 1, true},
+		{"replayed code", code, current, false},
+		{"code older than the skew", stale, 0, false},
+		{"wrong code", "000000x", 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := totpStep(tt.code, testTOTPSecret, tt.lastStep, now)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && step != current {
+			t.Errorf("%s: step = %d, want %d", tt.name, step, current)
+		}
+	}
+}
+
+func TestIsRecoveryCode(t *testing.T) {
+	tests := map[string]bool{
+		"abcde-fgh23":   true,
+		"ABCDEFGH23":    true,
+		" abcde-fgh23 ": true,
+		"123456":        false,
+		"abcde-fgh21":   false,
+		"abcdefgh2":     false,
+	}
+	for code, want := range tests {
+		if got := isRecoveryCode(code); got != want {
+			t.Errorf("isRecoveryCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+// twoFactorUser answers the statements of verifyTwoFactor for a user with
+// testTOTPSecret whose counters start at the given values
+func twoFactorUser(lastStep int64, locked bool, sessionFailures int, userLocks bool) func(string, []driver.Value) fakeResult {
+	return func(query string, args []driver.Value) fakeResult {
+		switch {
+		case strings.HasPrefix(query, "select totp_secret, totp_last_step"):
+			return fakeRows([]string{"totp_secret", "totp_last_step", "locked"}, []driver.Value{testTOTPSecret, lastStep, locked})
+		case strings.HasPrefix(query, "update users set totp_last_step"):
+			if args[1].(int64) <= lastStep {
+				return fakeResult{}
+			}
+			return fakeResult{affected: 1}
+		case strings.HasPrefix(query, "update sessions set mfa_failures"):
+			return fakeRows([]string{"mfa_failures"}, []driver.Value{int64(sessionFailures + 1)})
+		case strings.HasPrefix(query, "update users set mfa_failures = case"):
+			return fakeRows([]string{"locked"}, []driver.Value{userLocks})
+		case strings.HasPrefix(query, "select id, code_hash from recovery_codes"):
+			return fakeRows([]string{"id", "code_hash"})
+		case strings.HasPrefix(query, "update users set mfa_failures = 0"),
+			strings.HasPrefix(query, "update sessions set mfa_pending"),
+			strings.HasPrefix(query, "delete from sessions"):
+			return fakeResult{affected: 1}
+		}
+		return fakeResult{err: errors.New("unexpected query: " + query)}
+	}
+}
+
+func TestVerifyTwoFactor(t *testing.T) {
+	now := time.Now()
+	current := now.Unix() / totpPeriod
+	code, _ := totp.GenerateCode(testTOTPSecret, now)
+
+	tests := []struct {
+		name            string
+		code            string
+		lastStep        int64
+		locked          bool
+		sessionFailures int
+		userLocks       bool
+		want            int
+		ended           bool
+		recoveryChecked bool
+	}{
+		{name: "valid code", code: code, want: http.StatusFound},
+		{name: "replayed code", code: code, lastStep: current, want: http.StatusUnauthorized},
+		{name: "wrong code", code: "000000", want: http.StatusUnauthorized},
+		{name: "recovery code is checked", code: "abcde-fgh23", want: http.StatusUnauthorized, recoveryChecked: true},
+		{name: "session limit ends the session", code: "000000", sessionFailures: sessionFailureLimit - 1, want: http.StatusUnauthorized, ended: true},
+		{name: "user limit ends the session", code: "000000", userLocks: true, want: http.StatusUnauthorized, ended: true},
+		{name: "locked user is refused", code: code, locked: true, want: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac, _ := newTestContext()
+			db := &fakeDB{respond: twoFactorUser(tt.lastStep, tt.locked, tt.sessionFailures, tt.userLocks)}
+			ac.DB = db.open()
+
+			r := gin.New()
+			r.SetHTMLTemplate(template.Must(template.New("").Parse(
+				`{{define "main/twofactor"}}2fa {{.error}}{{end}}{{define "main/login"}}login {{.error}}{{end}}`)))
+			r.POST("/login/2fa", func(c *gin.Context) {
+				c.Set("user", &User{ID: "u1", Username: "alice", Role: RoleViewer, Enabled: true})
+				c.Set("mfaPending", mfaVerify)
+			}, ac.verifyTwoFactor)
+
+			req := httptest.NewRequest("POST", "/login/2fa", strings.NewReader(url.Values{"code": {tt.code}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "tok"})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if db.ran("delete from sessions") != tt.ended {
+				t.Errorf("session deleted = %v, want %v", !tt.ended, tt.ended)
+			}
+			if tt.ended && !strings.HasPrefix(w.Body.String(), "login") {
+				t.Errorf("ended session shows %q, want the login page", w.Body.String())
+			}
+			if db.ran("recovery_codes") != tt.recoveryChecked {
+				t.Errorf("recovery codes checked = %v, want %v", !tt.recoveryChecked, tt.recoveryChecked)
+			}
+			if tt.locked && db.ran("update") {
+				t.Error("a locked user's code was processed")
+			}
+		})
+	}
+}
+
+func TestTwoFactorHelpersUseTheRequestContext(t *testing.T) {
+	ac, _ := newTestContext()
+	ac.DB = (&fakeDB{respond: twoFactorUser(0, false, 0, false)}).open()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/login/2fa", nil).WithContext(ctx)
+
+	if _, err := ac.pendingSecondFactor(c, "u1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("pendingSecondFactor: %v, want context.Canceled", err)
+	}
+	if _, err := ac.useRecoveryCode(c, "u1", "abcde-fgh23"); !errors.Is(err, context.Canceled) {
+		t.Errorf("useRecoveryCode: %v, want context.Canceled", err)
+	}
+	if _, err := ac.resetRecoveryCodes(c, "u1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("resetRecoveryCodes: %v, want context.Canceled", err)
+	}
+}
+
+// twoFactorAccount is the database side of one enrolled user, keeping the
+// counters twoFactorFailed and useTOTPStep update
+type twoFactorAccount struct {
+	mu              sync.Mutex
+	lastStep        int64
+	userFailures    int
+	lockedUntil     time.Time
+	sessionFailures map[string]int
+	sessionsEnded   int
+	disabled        int
+}
+
+func (a *twoFactorAccount) respond(query string, args []driver.Value) fakeResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, "select value from settings"):
+		return fakeRows([]string{"value"})
+	case strings.HasPrefix(query, "select totp_secret, totp_last_step"):
+		return fakeRows([]string{"totp_secret", "totp_last_step", "locked"}, []driver.Value{testTOTPSecret, a.lastStep, time.Now().Before(a.lockedUntil)})
+	case strings.HasPrefix(query, "update users set totp_last_step"):
+		if args[1].(int64) <= a.lastStep {
+			return fakeResult{}
+		}
+		a.lastStep = args[1].(int64)
+		return fakeResult{affected: 1}
+	case strings.HasPrefix(query, "update sessions set mfa_failures"):
+		a.sessionFailures[args[0].(string)]++
+		return fakeRows([]string{"mfa_failures"}, []driver.Value{int64(a.sessionFailures[args[0].(string)])})
+	case strings.HasPrefix(query, "update users set mfa_failures = case"):
+		a.userFailures++
+		if a.userFailures >= userFailureLimit {
+			a.userFailures = 0
+			a.lockedUntil = time.Now().Add(twoFactorLockout)
+		}
+		return fakeRows([]string{"locked"}, []driver.Value{time.Now().Before(a.lockedUntil)})
+	case strings.HasPrefix(query, "delete from sessions"):
+		a.sessionsEnded++
+		return fakeResult{affected: 1}
+	case strings.HasPrefix(query, "update users set totp_secret = null"):
+		a.disabled++
+		return fakeResult{affected: 1}
+	case strings.HasPrefix(query, "delete from recovery_codes"):
+		return fakeResult{affected: 1}
+	}
+	return fakeResult{err: errors.New("unexpected query: " + query)}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	ac, _ := newTestContext()
+	account := &twoFactorAccount{sessionFailures: map[string]int{}}
+	ac.DB = (&fakeDB{respond: account.respond}).open()
+
+	r := gin.New()
+	r.POST("/account/2fa/disable", func(c *gin.Context) {
+		c.Set("user", &User{ID: "u1", Username: "alice", Role: RoleViewer, Enabled: true})
+	}, ac.disableTwoFactor)
+	disable := func(session string, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/account/2fa/disable", strings.NewReader(url.Values{"code": {code}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	code, _ := totp.GenerateCode(testTOTPSecret, time.Now())
+	if w := disable("s0", code); w.Code != http.StatusOK || account.disabled != 1 {
+		t.Fatalf("valid code: status %d, disabled %d times", w.Code, account.disabled)
+	}
+	if w := disable("s0", code); w.Code != http.StatusUnauthorized || account.disabled != 1 {
+		t.Errorf("reused code: status %d, disabled %d times", w.Code, account.disabled)
+	}
+
+	// the reuse above was the first failure of session s0
+	for i := 2; i <= sessionFailureLimit; i++ {
+		w := disable("s0", "000000")
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: status %d", i, w.Code)
+		}
+		if ended := strings.Contains(w.Header().Get("Set-Cookie"), sessionCookie+"=;"); ended != (i == sessionFailureLimit) {
+			t.Errorf("wrong code %d: session cookie cleared = %v", i, ended)
+		}
+	}
+	if account.sessionsEnded != 1 {
+		t.Errorf("%d sessions ended at the session limit, want 1", account.sessionsEnded)
+	}
+
+	// a fresh session each time, the user limit still locks the account
+	for i := sessionFailureLimit + 1; i <= userFailureLimit; i++ {
+		disable("s"+strconv.Itoa(i), "000000")
+	}
+	next, _ := totp.GenerateCode(testTOTPSecret, time.Now().Add(totpPeriod*time.Second))
+	if w := disable("fresh", next); w.Code != http.StatusTooManyRequests || account.disabled != 1 {
+		t.Errorf("locked account: status %d, disabled %d times", w.Code, account.disabled)
+	}
+}